@@ -1,158 +1,211 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/forwarded"
 	"github.com/jmservic/httpfromtcp/internal/headers"
 	"github.com/jmservic/httpfromtcp/internal/request"
 	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/router"
 	"github.com/jmservic/httpfromtcp/internal/server"
+	"html"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
 
+// shutdownTimeout bounds how long in-flight requests get to finish once a
+// stop signal arrives.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	server, err := server.Serve(port, proxyHandler)
+	trusted, err := forwarded.NewTrustedProxies("127.0.0.1/8", "::1")
+	if err != nil {
+		log.Fatalf("Error configuring trusted proxies: %v", err)
+	}
+	routes := newRouter(trusted)
+	server, err := server.Serve(port, trusted.Wrap(routes.ServeHTTP),
+		server.WithServerName("httpfromtcp"),
+		server.WithOptionsHandler(trusted.Wrap(routes.ServeHTTP)),
+		server.WithErrorRenderer(htmlError),
+		server.WithReadHeaderTimeout(10*time.Second),
+		server.WithReadBodyTimeout(30*time.Second),
+		server.WithWriteTimeout(time.Minute),
+		server.WithIdleTimeout(2*time.Minute),
+		server.WithMaxConns(1024),
+		server.WithMaxConcurrentHandlers(256),
+	)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down, closed remaining connections: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func mainHandler(w *response.Writer, req *request.Request) {
-
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		badRequest(w)
-	case "/myproblem":
-		internalError(w)
-	case "/video":
-		videoResponse(w)
-	default:
-		goodRequest(w)
+// newRouter returns the server's routes. Forwarding headers are only relayed
+// to httpbin from peers in trusted.
+func newRouter(trusted *forwarded.TrustedProxies) *router.Router {
+	r := router.New()
+	r.ErrorRenderer = htmlError
+	r.Use(server.AccessLog(nil))
+	handle := func(h server.ErrorHandler) server.Handler {
+		return server.HandleErrors(h, htmlError)
 	}
+	r.Get("/yourproblem", handle(func(w *response.Writer, req *request.Request) error {
+		return server.HandlerError{StatusCode: response.StatusBadRequest, Message: "Your request honestly kinda sucked."}
+	}))
+	r.Get("/myproblem", handle(func(w *response.Writer, req *request.Request) error {
+		return server.HandlerError{StatusCode: response.StatusInternalServerError, Message: "Okay, you know what? This one is on me."}
+	}))
+	r.Get("/video", handle(videoResponse))
+
+	httpbin := router.New()
+	httpbin.ErrorRenderer = htmlError
+	httpbin.Get("/{path...}", handle(func(w *response.Writer, req *request.Request) error {
+		return proxyHandler(w, req, trusted)
+	}))
+	r.Mount("/httpbin", httpbin.ServeHTTP)
+
+	r.Get("/{path...}", goodRequest)
+	return r
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-		url := "https://httpbin.org/" + path
-		fmt.Println("Proxing to", url)
-		resp, err := http.Get(url)
-		if err != nil {
-			badRequest(w)
+// proxyHandler relays the request to httpbin.org, streaming the response
+// back chunked with trailers carrying the body's hash and length. It is
+// mounted below /httpbin, so the target it sees is already httpbin's path.
+func proxyHandler(w *response.Writer, req *request.Request, trusted *forwarded.TrustedProxies) error {
+	url := "https://httpbin.org" + req.RequestLine.RequestTarget
+	fmt.Println("Proxing to", url)
+	upstreamReq, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return server.HandlerError{StatusCode: response.StatusBadRequest, Message: "That is not a path httpbin would understand."}
+	}
+	for key, val := range forwardingHeaders(req, trusted) {
+		upstreamReq.Header.Set(key, val)
+	}
+	resp, err := http.DefaultClient.Do(upstreamReq)
+	if err != nil {
+		return server.HandlerError{StatusCode: response.StatusBadGateway, Message: "httpbin did not answer."}
+	}
+	defer resp.Body.Close()
+
+	h := headers.NewHeaders()
+	for key, vals := range resp.Header {
+		for _, val := range vals {
+			h.Set(key, val)
 		}
+	}
+	headers.StripHopByHop(h)
+	// the body is re-framed as chunks below, so the upstream length no longer applies
+	h.Delete("Content-Length")
+	h.Set("Connection", "close")
+	h.Replace("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-SHA256")
+	h.Set("Trailer", "X-Content-Length")
+	_, reason, _ := strings.Cut(resp.Status, " ")
+	w.WriteStatusLineReason(response.StatusCode(resp.StatusCode), reason)
+	w.WriteHeaders(h)
 
-		h := response.GetDefaultHeaders(0)
-		//for key, vals := range resp.Header {
-		//	for _, val := range vals {
-		//		headers.Set(key, val)
-		//	}
-		//}
-		h.Delete("Content-Length")
-		//h.Delete("Connection")
-		h.Replace("Transfer-Encoding", "chunked")
-		h.Set("Trailer", "X-Content-SHA256")
-		h.Set("Trailer", "X-Content-Length")
-		w.WriteStatusLine(response.StatusCode(resp.StatusCode))
-		w.WriteHeaders(h)
-
-		const maxChunkSize = 1024
-		fullResponse := make([]byte, 0)
-		buffer := make([]byte, maxChunkSize)
-
-		for {
-			n, err := resp.Body.Read(buffer)
-			fmt.Printf("Read %d bytes from the response body\n", n)
-			if n > 0 {
-				fullResponse = append(fullResponse, buffer[:n]...)
-				_, err = w.WriteChunkedBody(buffer[:n])
-				if err != nil {
-					fmt.Println("Error writing chunked body:")
-					break
-				}
-			}
-			if err == io.EOF {
-				break
-			}
+	const maxChunkSize = 1024
+	fullResponse := make([]byte, 0)
+	buffer := make([]byte, maxChunkSize)
+
+	for {
+		n, err := resp.Body.Read(buffer)
+		fmt.Printf("Read %d bytes from the response body\n", n)
+		if n > 0 {
+			fullResponse = append(fullResponse, buffer[:n]...)
+			_, err = w.WriteChunkedBody(buffer[:n])
 			if err != nil {
-				fmt.Println("Error reading response body:", err)
+				fmt.Println("Error writing chunked body:")
 				break
 			}
 		}
-
-		_, err = w.WriteChunkedBodyDone(true)
-		if err != nil {
-			fmt.Println(err)
+		if err == io.EOF {
+			break
 		}
-		trailers := headers.NewHeaders()
-		responseHash := sha256.Sum256(fullResponse)
-		//	fmt.Printf("%x | %d\n", responseHash, len(fullResponse))
-		//	fmt.Print(string(fullResponse))
-		//trailers["X-Content-Sha256"] = fmt.Sprintf("%x", responseHash)
-		//trailers["X-Content-Length"] = fmt.Sprintf("%d", len(fullResponse))
-		trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", responseHash))
-		trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullResponse)))
-		//	hResponse, _ := trailers.Get("X-Content-SHA256")
-		//	hLength, _ := trailers.Get("X-Content-Length")
-		//	fmt.Println(hResponse, hLength)
-		err = w.WriteTrailers(trailers)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println("Error reading response body:", err)
+			break
 		}
-	} else {
-		mainHandler(w, req)
 	}
+
+	_, err = w.WriteChunkedBodyDone(true)
+	if err != nil {
+		fmt.Println(err)
+	}
+	trailers := headers.NewHeaders()
+	responseHash := sha256.Sum256(fullResponse)
+	//	fmt.Printf("%x | %d\n", responseHash, len(fullResponse))
+	//	fmt.Print(string(fullResponse))
+	//trailers["X-Content-Sha256"] = fmt.Sprintf("%x", responseHash)
+	//trailers["X-Content-Length"] = fmt.Sprintf("%d", len(fullResponse))
+	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", responseHash))
+	trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullResponse)))
+	//	hResponse, _ := trailers.Get("X-Content-SHA256")
+	//	hLength, _ := trailers.Get("X-Content-Length")
+	//	fmt.Println(hResponse, hLength)
+	err = w.WriteTrailers(trailers)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return nil
 }
 
-func badRequest(w *response.Writer) {
-	body := []byte(`<html>
-	  <head>
-	    <title>400 Bad Request</title>
-	  </head>
-	  <body>
-	    <h1>Bad Request</h1>
-	    <p>Your request honestly kinda sucked.</p>
-	  </body>
-	</html>`)
-	headers := response.GetDefaultHeaders(len(body))
-	headers.Replace("Content-Type", "text/html")
-	w.WriteStatusLine(response.StatusBadRequest)
-	w.WriteHeaders(headers)
-	w.WriteBody(body)
+// forwardingHeaders returns the Forwarded and X-Forwarded-* headers to send
+// upstream. The chain the request already carried is only extended when its
+// peer is a trusted proxy; anyone else could have forged it.
+func forwardingHeaders(req *request.Request, trusted *forwarded.TrustedProxies) headers.Headers {
+	h := headers.NewHeaders()
+	if trusted.Trusted(req.RemoteAddr) {
+		for _, field := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"} {
+			if val, ok := req.Headers.Get(field); ok {
+				h.Set(field, val)
+			}
+		}
+	}
+	host, _ := req.Headers.Get("Host")
+	forwarded.Append(h, req.RemoteAddr, "http", host)
+	return h
 }
 
-func internalError(w *response.Writer) {
-	body := []byte(`<html>
+// htmlError renders handler errors as a small HTML page.
+func htmlError(herr server.HandlerError) (string, []byte) {
+	title := fmt.Sprintf("%d %s", herr.StatusCode, response.StatusText(herr.StatusCode))
+	body := fmt.Sprintf(`<html>
 	  <head>
-	    <title>500 Internal Server Error</title>
+	    <title>%s</title>
 	  </head>
 	  <body>
-	    <h1>Internal Server Error</h1>
-	    <p>Okay, you know what? This one is on me.</p>
+	    <h1>%s</h1>
+	    <p>%s</p>
 	  </body>
-	</html>`)
-	headers := response.GetDefaultHeaders(len(body))
-	headers.Replace("Content-Type", "text/html")
-	w.WriteStatusLine(response.StatusInternalServerError)
-	w.WriteHeaders(headers)
-	w.WriteBody(body)
+	</html>`, title, response.StatusText(herr.StatusCode), html.EscapeString(herr.Error()))
+	return "text/html", []byte(body)
 }
 
-func goodRequest(w *response.Writer) {
+func goodRequest(w *response.Writer, req *request.Request) {
 	body := []byte(`<html>
 	  <head>
 	    <title>200 OK</title>
@@ -164,20 +217,23 @@ func goodRequest(w *response.Writer) {
 	</html>`)
 	headers := response.GetDefaultHeaders(len(body))
 	headers.Replace("Content-Type", "text/html")
-	w.WriteStatusLine(response.StatusInternalServerError)
+	w.WriteStatusLine(response.StatusOK)
 	w.WriteHeaders(headers)
 	w.WriteBody(body)
 }
 
-func videoResponse(w *response.Writer) {
-	video, err := os.ReadFile("assets/vim.mp4")
+func videoResponse(w *response.Writer, req *request.Request) error {
+	video, err := os.Open("assets/vim.mp4")
 	if err != nil {
-		fmt.Printf("error opening the video file: %v\n", err)
-		return
+		return fmt.Errorf("error opening the video file: %w", err)
 	}
-	headers := response.GetDefaultHeaders(len(video))
-	headers.Replace("Content-Type", "video/mp4")
-	w.WriteStatusLine(response.StatusOK)
-	w.WriteHeaders(headers)
-	w.WriteBody(video)
+	defer video.Close()
+	w.Header().Replace("Content-Type", "video/mp4")
+	if info, err := video.Stat(); err == nil {
+		w.Header().Replace("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	if _, err := io.Copy(w, video); err != nil {
+		return fmt.Errorf("error sending the video file: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/forwarded"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/responsetest"
+	"github.com/jmservic/httpfromtcp/internal/router"
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTrusted(t *testing.T) *forwarded.TrustedProxies {
+	t.Helper()
+	trusted, err := forwarded.NewTrustedProxies("127.0.0.1/8")
+	require.NoError(t, err)
+	return trusted
+}
+
+func testRouter(t *testing.T) *router.Router {
+	t.Helper()
+	return newRouter(testTrusted(t))
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		target     string
+		statusCode response.StatusCode
+		title      string
+	}{
+		{"/", response.StatusOK, "<title>200 OK</title>"},
+		{"/yourproblem", response.StatusBadRequest, "<title>400 Bad Request</title>"},
+		{"/myproblem", response.StatusInternalServerError, "<title>500 Internal Server Error</title>"},
+		{"/anything/else", response.StatusOK, "<title>200 OK</title>"},
+	}
+
+	for _, tt := range tests {
+		req, err := responsetest.NewRequest("GET " + tt.target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+		require.NoError(t, err)
+		rec := responsetest.NewRecorder()
+		testRouter(t).ServeHTTP(rec.Writer, req)
+
+		res, err := rec.Result()
+		require.NoError(t, err, tt.target)
+		assert.Equal(t, tt.statusCode, res.StatusCode, tt.target)
+		assert.Equal(t, "text/html", res.Headers["content-type"], tt.target)
+		assert.Contains(t, string(res.Body), tt.title, tt.target)
+	}
+}
+
+func TestRoutesHead(t *testing.T) {
+	req, err := responsetest.NewRequest("HEAD / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	testRouter(t).ServeHTTP(rec.Writer, req)
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.NotEqual(t, "0", res.Headers["content-length"])
+	assert.Empty(t, res.Body)
+}
+
+func TestRoutesMethodNotAllowed(t *testing.T) {
+	req, err := responsetest.NewRequest("POST /video HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 0\r\n\r\n")
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	testRouter(t).ServeHTTP(rec.Writer, req)
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers["allow"])
+	assert.Contains(t, string(res.Body), "<title>405 Method Not Allowed</title>")
+}
+
+func TestRoutesOptions(t *testing.T) {
+	req, err := responsetest.NewRequest("OPTIONS /video HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	testRouter(t).ServeHTTP(rec.Writer, req)
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers["allow"])
+	assert.Empty(t, res.Body)
+}
+
+func TestForwardingHeaders(t *testing.T) {
+	trusted := testTrusted(t)
+	spoofed := "GET /get HTTP/1.1\r\nHost: localhost:42069\r\n" +
+		"X-Forwarded-For: 6.6.6.6\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: evil.test\r\n\r\n"
+
+	// Test: An untrusted peer's chain is replaced
+	req, err := responsetest.NewRequest(spoofed)
+	require.NoError(t, err)
+	req.RemoteAddr = "198.51.100.7:5000"
+	h := forwardingHeaders(req, trusted)
+	assert.Equal(t, "198.51.100.7", h["x-forwarded-for"])
+	assert.Equal(t, "http", h["x-forwarded-proto"])
+	assert.Equal(t, "localhost:42069", h["x-forwarded-host"])
+
+	// Test: A trusted proxy's chain is extended
+	req, err = responsetest.NewRequest(spoofed)
+	require.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:5000"
+	h = forwardingHeaders(req, trusted)
+	assert.Equal(t, "6.6.6.6, 127.0.0.1", h["x-forwarded-for"])
+	assert.Equal(t, "https", h["x-forwarded-proto"])
+	assert.Equal(t, "evil.test", h["x-forwarded-host"])
+}
+
+func TestRoutesAbsoluteForm(t *testing.T) {
+	s, err := server.Serve(0, testRouter(t).ServeHTTP, server.WithLogger(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: An absolute-form target is routed by its path
+	_, err = io.WriteString(conn, "GET http://localhost:42069/ HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n"), string(resp))
+	assert.Contains(t, string(resp), "<title>200 OK</title>")
+}
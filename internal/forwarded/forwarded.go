@@ -0,0 +1,289 @@
+package forwarded
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"net"
+	"strings"
+)
+
+// Element is a single forwarded-element of an RFC 7239 Forwarded header,
+// describing one hop. Empty fields were not present in the element.
+type Element struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// Client describes the original client of a request as seen by the first
+// trusted proxy in the chain.
+type Client struct {
+	Addr  string
+	Proto string
+	Host  string
+}
+
+// TrustedProxies is the set of networks whose forwarding headers are
+// believed. Requests from any other peer have their forwarding headers
+// ignored.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	t := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network: %w", err)
+		}
+		t.nets = append(t.nets, ipNet)
+	}
+	return t, nil
+}
+
+// Trusted reports whether addr, an IP address optionally followed by a
+// port, belongs to one of the trusted networks.
+func (t *TrustedProxies) Trusted(addr string) bool {
+	ip := net.ParseIP(stripPort(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range t.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve determines the original client of req from its peer address and
+// forwarding headers, and records the client address in req.ClientAddr.
+// The Forwarded header takes precedence over the X-Forwarded-* headers.
+// The chain is walked from the nearest hop outwards and the first address
+// that is not a trusted proxy is taken as the client. If that hop is
+// "unknown" or an obfuscated identifier, the peer address is kept.
+func (t *TrustedProxies) Resolve(req *request.Request) Client {
+	client := Client{
+		Addr:  stripPort(req.RemoteAddr),
+		Proto: "http",
+	}
+	client.Host, _ = req.Headers.Get("Host")
+
+	if t.Trusted(req.RemoteAddr) {
+		elements, ok := fromForwarded(req.Headers)
+		if !ok {
+			elements, ok = fromXForwarded(req.Headers)
+		}
+		if ok {
+			hop := elements[t.clientIndex(elements)]
+			if addr := stripPort(hop.For); isAddress(addr) {
+				client.Addr = addr
+			}
+			if hop.Proto != "" {
+				client.Proto = hop.Proto
+			}
+			if hop.Host != "" {
+				client.Host = hop.Host
+			}
+		}
+	}
+
+	req.ClientAddr = client.Addr
+	return client
+}
+
+// Wrap returns a handler that resolves the client of each request before
+// calling next.
+func (t *TrustedProxies) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		t.Resolve(req)
+		next(w, req)
+	}
+}
+
+func fromForwarded(h headers.Headers) ([]Element, bool) {
+	value, ok := h.Get("Forwarded")
+	if !ok {
+		return nil, false
+	}
+	elements, err := Parse(value)
+	if err != nil || len(elements) == 0 {
+		return nil, false
+	}
+	for _, element := range elements {
+		if element.For == "" {
+			return nil, false
+		}
+	}
+	return elements, true
+}
+
+func fromXForwarded(h headers.Headers) ([]Element, bool) {
+	value, ok := h.Get("X-Forwarded-For")
+	if !ok {
+		return nil, false
+	}
+	elements := []Element{}
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			return nil, false
+		}
+		elements = append(elements, Element{For: addr})
+	}
+
+	// X-Forwarded-Proto and X-Forwarded-Host are usually set once by the
+	// edge proxy, but some proxies append to them alongside X-Forwarded-For.
+	for _, field := range []string{"X-Forwarded-Proto", "X-Forwarded-Host"} {
+		value, ok := h.Get(field)
+		if !ok {
+			continue
+		}
+		values := strings.Split(value, ",")
+		for i := range elements {
+			v := values[0]
+			if len(values) == len(elements) {
+				v = values[i]
+			}
+			v = strings.TrimSpace(v)
+			if field == "X-Forwarded-Proto" {
+				elements[i].Proto = strings.ToLower(v)
+			} else {
+				elements[i].Host = v
+			}
+		}
+	}
+	return elements, true
+}
+
+func (t *TrustedProxies) clientIndex(elements []Element) int {
+	for i := len(elements) - 1; i > 0; i-- {
+		if !t.Trusted(elements[i].For) {
+			return i
+		}
+	}
+	return 0
+}
+
+// isAddress reports whether node, stripped of its port, is an address rather
+// than "unknown" or an obfuscated identifier such as "_hidden".
+func isAddress(node string) bool {
+	return node != "" && !strings.EqualFold(node, "unknown") && !strings.HasPrefix(node, "_")
+}
+
+// Parse parses the value of a Forwarded header into its elements, in the
+// order the hops were added.
+func Parse(value string) ([]Element, error) {
+	elements := []Element{}
+	for _, rawElement := range headers.SplitQuoted(value, ',') {
+		if strings.TrimSpace(rawElement) == "" {
+			continue
+		}
+		element := Element{}
+		for _, pair := range headers.SplitQuoted(rawElement, ';') {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			name, val, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, fmt.Errorf("forwarded-pair %q is missing a value", pair)
+			}
+			val, err := headers.Unquote(strings.TrimSpace(val))
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(name) {
+			case "for":
+				element.For = val
+			case "by":
+				element.By = val
+			case "host":
+				element.Host = val
+			case "proto":
+				element.Proto = strings.ToLower(val)
+			}
+		}
+		elements = append(elements, element)
+	}
+	if len(elements) == 0 {
+		return nil, errors.New("empty Forwarded header")
+	}
+	return elements, nil
+}
+
+// Append adds the forwarding headers for a request received from
+// remoteAddr to h, for use when relaying the request upstream. Forwarded
+// and X-Forwarded-For gain a new hop, while X-Forwarded-Proto and
+// X-Forwarded-Host are only set if no earlier proxy set them.
+func Append(h headers.Headers, remoteAddr, proto, host string) {
+	node := stripPort(remoteAddr)
+	element := "for=" + formatNode(remoteAddr)
+	if host != "" {
+		element += ";host=" + quoteIfNeeded(host)
+	}
+	if proto != "" {
+		element += ";proto=" + proto
+	}
+	h.Set("Forwarded", element)
+	h.Set("X-Forwarded-For", node)
+	if _, ok := h.Get("X-Forwarded-Proto"); !ok && proto != "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if _, ok := h.Get("X-Forwarded-Host"); !ok && host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+}
+
+// formatNode renders an address as an RFC 7239 node, bracketing and
+// quoting IPv6 addresses and quoting anything carrying a port.
+func formatNode(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		return `"` + host + ":" + port + `"`
+	}
+	if ip != nil && ip.To4() == nil {
+		return `"` + host + `"`
+	}
+	return host
+}
+
+func quoteIfNeeded(value string) string {
+	if headers.IsToken(value) {
+		return value
+	}
+	return headers.Quote(value)
+}
+
+// stripPort removes the port and IPv6 brackets from a node, leaving the
+// address or obfuscated identifier.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,96 @@
+package forwarded
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newRequest(remoteAddr string, h map[string]string) *request.Request {
+	req := &request.Request{
+		Headers:    headers.NewHeaders(),
+		RemoteAddr: remoteAddr,
+	}
+	for key, val := range h {
+		req.Headers.Set(key, val)
+	}
+	return req
+}
+
+func TestParse(t *testing.T) {
+	// Test: Multiple elements with quoted values
+	elements, err := Parse(`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711";host="example.com:8080"`)
+	require.NoError(t, err)
+	require.Len(t, elements, 2)
+	assert.Equal(t, Element{For: "192.0.2.60", By: "203.0.113.43", Proto: "http"}, elements[0])
+	assert.Equal(t, Element{For: "[2001:db8:cafe::17]:4711", Host: "example.com:8080"}, elements[1])
+
+	// Test: Pair without a value
+	_, err = Parse("for")
+	require.Error(t, err)
+
+	// Test: Empty header
+	_, err = Parse(" , ")
+	require.Error(t, err)
+}
+
+func TestResolve(t *testing.T) {
+	trusted, err := NewTrustedProxies("10.0.0.0/8", "::1")
+	require.NoError(t, err)
+
+	// Test: Untrusted peer headers are ignored
+	req := newRequest("198.51.100.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4", "Host": "site.test"})
+	client := trusted.Resolve(req)
+	assert.Equal(t, Client{Addr: "198.51.100.7", Proto: "http", Host: "site.test"}, client)
+	assert.Equal(t, "198.51.100.7", req.ClientAddr)
+
+	// Test: X-Forwarded-For skips trusted hops and ignores spoofed entries
+	req = newRequest("10.0.0.1:5000", map[string]string{
+		"X-Forwarded-For":   "6.6.6.6, 203.0.113.9, 10.0.0.2",
+		"X-Forwarded-Proto": "HTTPS",
+		"X-Forwarded-Host":  "example.com",
+	})
+	client = trusted.Resolve(req)
+	assert.Equal(t, Client{Addr: "203.0.113.9", Proto: "https", Host: "example.com"}, client)
+
+	// Test: Forwarded takes precedence over X-Forwarded-For
+	req = newRequest("[::1]:5000", map[string]string{
+		"Forwarded":       `for="[2001:db8::1]:4711";proto=https;host=example.org`,
+		"X-Forwarded-For": "1.2.3.4",
+	})
+	client = trusted.Resolve(req)
+	assert.Equal(t, Client{Addr: "2001:db8::1", Proto: "https", Host: "example.org"}, client)
+
+	// Test: Unknown and obfuscated hops keep the peer address
+	for _, node := range []string{"unknown", "_hidden", `"_hidden:_port"`} {
+		req = newRequest("10.0.0.1:5000", map[string]string{"Forwarded": "for=" + node + ";proto=https"})
+		client = trusted.Resolve(req)
+		assert.Equal(t, Client{Addr: "10.0.0.1", Proto: "https"}, client, node)
+		assert.Equal(t, "10.0.0.1", req.ClientAddr, node)
+	}
+
+	// Test: Invalid trusted network
+	_, err = NewTrustedProxies("10.0.0.0/99")
+	require.Error(t, err)
+}
+
+func TestAppend(t *testing.T) {
+	// Test: First hop
+	h := headers.NewHeaders()
+	Append(h, "192.0.2.1:1234", "http", "localhost:42069")
+	assert.Equal(t, `for="192.0.2.1:1234";host="localhost:42069";proto=http`, h["forwarded"])
+	assert.Equal(t, "192.0.2.1", h["x-forwarded-for"])
+	assert.Equal(t, "http", h["x-forwarded-proto"])
+	assert.Equal(t, "localhost:42069", h["x-forwarded-host"])
+
+	// Test: Extending an existing chain keeps the original proto and host
+	h = headers.NewHeaders()
+	h.Set("X-Forwarded-For", "203.0.113.9")
+	h.Set("X-Forwarded-Proto", "https")
+	Append(h, "[2001:db8::2]:80", "http", "internal")
+	assert.Equal(t, `for="[2001:db8::2]:80";host=internal;proto=http`, h["forwarded"])
+	assert.Equal(t, "203.0.113.9, 2001:db8::2", h["x-forwarded-for"])
+	assert.Equal(t, "https", h["x-forwarded-proto"])
+}
@@ -0,0 +1,206 @@
+package headers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CacheControl is a parsed Cache-Control header. Delta-seconds directives
+// that are not present are set to -1.
+type CacheControl struct {
+	MaxAge               int
+	SMaxAge              int
+	StaleWhileRevalidate int
+	NoCache              bool
+	NoCacheFields        []string
+	NoStore              bool
+	Private              bool
+	PrivateFields        []string
+	Public               bool
+	MustRevalidate       bool
+	Immutable            bool
+	// Extensions holds any directives not listed above, keyed by their
+	// lowercased name. Directives without an argument have an empty value.
+	Extensions map[string]string
+}
+
+func NewCacheControl() CacheControl {
+	return CacheControl{
+		MaxAge:               -1,
+		SMaxAge:              -1,
+		StaleWhileRevalidate: -1,
+	}
+}
+
+// ParseCacheControl parses the value of a Cache-Control header. When a
+// directive appears more than once the first occurrence wins.
+func ParseCacheControl(value string) (CacheControl, error) {
+	cc := NewCacheControl()
+	seen := map[string]bool{}
+	for _, part := range SplitQuoted(value, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, hasArg := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.IndexFunc(name, invalidToken) != -1 {
+			return cc, fmt.Errorf("invalid Cache-Control directive %q", part)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		arg, err := Unquote(strings.TrimSpace(arg))
+		if err != nil {
+			return cc, fmt.Errorf("invalid argument for %s: %w", name, err)
+		}
+
+		switch name {
+		case "max-age", "s-maxage", "stale-while-revalidate":
+			if !hasArg {
+				return cc, fmt.Errorf("%s requires an argument", name)
+			}
+			secs, err := parseDeltaSeconds(arg)
+			if err != nil {
+				return cc, fmt.Errorf("invalid argument for %s: %w", name, err)
+			}
+			switch name {
+			case "max-age":
+				cc.MaxAge = secs
+			case "s-maxage":
+				cc.SMaxAge = secs
+			default:
+				cc.StaleWhileRevalidate = secs
+			}
+		case "no-cache":
+			cc.NoCache = true
+			cc.NoCacheFields = fieldList(arg)
+		case "private":
+			cc.Private = true
+			cc.PrivateFields = fieldList(arg)
+		case "no-store":
+			cc.NoStore = true
+		case "public":
+			cc.Public = true
+		case "must-revalidate":
+			cc.MustRevalidate = true
+		case "immutable":
+			cc.Immutable = true
+		default:
+			if cc.Extensions == nil {
+				cc.Extensions = map[string]string{}
+			}
+			cc.Extensions[name] = arg
+		}
+	}
+	return cc, nil
+}
+
+// String returns the canonical form of the directives, suitable for use as
+// a Cache-Control header value.
+func (cc CacheControl) String() string {
+	directives := []string{}
+	if cc.Public {
+		directives = append(directives, "public")
+	}
+	if cc.Private {
+		directives = append(directives, withFields("private", cc.PrivateFields))
+	}
+	if cc.NoCache {
+		directives = append(directives, withFields("no-cache", cc.NoCacheFields))
+	}
+	if cc.NoStore {
+		directives = append(directives, "no-store")
+	}
+	if cc.MaxAge >= 0 {
+		directives = append(directives, "max-age="+strconv.Itoa(cc.MaxAge))
+	}
+	if cc.SMaxAge >= 0 {
+		directives = append(directives, "s-maxage="+strconv.Itoa(cc.SMaxAge))
+	}
+	if cc.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if cc.StaleWhileRevalidate >= 0 {
+		directives = append(directives, "stale-while-revalidate="+strconv.Itoa(cc.StaleWhileRevalidate))
+	}
+	if cc.Immutable {
+		directives = append(directives, "immutable")
+	}
+
+	names := make([]string, 0, len(cc.Extensions))
+	for name := range cc.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		arg := cc.Extensions[name]
+		switch {
+		case arg == "":
+			directives = append(directives, name)
+		case strings.IndexFunc(arg, invalidToken) == -1:
+			directives = append(directives, name+"="+arg)
+		default:
+			directives = append(directives, name+"="+Quote(arg))
+		}
+	}
+	return strings.Join(directives, ", ")
+}
+
+// CacheControl parses the Cache-Control header. If the header is missing
+// the result has no directives set.
+func (h Headers) CacheControl() (CacheControl, error) {
+	value, ok := h.Get("Cache-Control")
+	if !ok {
+		return NewCacheControl(), nil
+	}
+	return ParseCacheControl(value)
+}
+
+// SetCacheControl replaces the Cache-Control header with the canonical form
+// of cc, removing the header entirely when cc has no directives.
+func (h Headers) SetCacheControl(cc CacheControl) {
+	value := cc.String()
+	if value == "" {
+		h.Delete("Cache-Control")
+		return
+	}
+	h.Replace("Cache-Control", value)
+}
+
+func parseDeltaSeconds(s string) (int, error) {
+	if s == "" || strings.ContainsFunc(s, func(r rune) bool { return r < '0' || r > '9' }) {
+		return 0, fmt.Errorf("%q is not a delta-seconds value", s)
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil {
+		// values too large to represent are treated as "infinity" per RFC 9111
+		return 1<<31 - 1, nil
+	}
+	return secs, nil
+}
+
+func fieldList(arg string) []string {
+	if arg == "" {
+		return nil
+	}
+	fields := []string{}
+	for _, field := range strings.Split(arg, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func withFields(name string, fields []string) string {
+	if len(fields) == 0 {
+		return name
+	}
+	return name + "=\"" + strings.Join(fields, ", ") + "\""
+}
@@ -0,0 +1,66 @@
+package headers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCacheControlParse(t *testing.T) {
+	// Test: Common response directives
+	cc, err := ParseCacheControl("public, max-age=3600, s-maxage=60, must-revalidate")
+	require.NoError(t, err)
+	assert.True(t, cc.Public)
+	assert.True(t, cc.MustRevalidate)
+	assert.Equal(t, 3600, cc.MaxAge)
+	assert.Equal(t, 60, cc.SMaxAge)
+	assert.Equal(t, -1, cc.StaleWhileRevalidate)
+	assert.False(t, cc.NoStore)
+
+	// Test: Case insensitive names and quoted field lists
+	cc, err = ParseCacheControl(`No-Cache="Set-Cookie, X-Session", PRIVATE, no-store`)
+	require.NoError(t, err)
+	assert.True(t, cc.NoCache)
+	assert.Equal(t, []string{"set-cookie", "x-session"}, cc.NoCacheFields)
+	assert.True(t, cc.Private)
+	assert.Nil(t, cc.PrivateFields)
+	assert.True(t, cc.NoStore)
+
+	// Test: First occurrence wins and extensions are kept
+	cc, err = ParseCacheControl(`max-age=10, max-age=20, immutable, stale-while-revalidate=30, community="UCI"`)
+	require.NoError(t, err)
+	assert.Equal(t, 10, cc.MaxAge)
+	assert.True(t, cc.Immutable)
+	assert.Equal(t, 30, cc.StaleWhileRevalidate)
+	assert.Equal(t, map[string]string{"community": "UCI"}, cc.Extensions)
+
+	// Test: Invalid delta-seconds
+	_, err = ParseCacheControl("max-age=-5")
+	require.Error(t, err)
+	_, err = ParseCacheControl("max-age")
+	require.Error(t, err)
+
+	// Test: Unterminated quoted string
+	_, err = ParseCacheControl(`no-cache="set-cookie`)
+	require.Error(t, err)
+}
+
+func TestCacheControlHeaders(t *testing.T) {
+	// Test: Missing header
+	h := NewHeaders()
+	cc, err := h.CacheControl()
+	require.NoError(t, err)
+	assert.Equal(t, "", cc.String())
+
+	// Test: Round trip in canonical order
+	h.Set("Cache-Control", `max-age=0, no-cache="set-cookie", private, x-custom="a b"`)
+	cc, err = h.CacheControl()
+	require.NoError(t, err)
+	h.SetCacheControl(cc)
+	assert.Equal(t, `private, no-cache="set-cookie", max-age=0, x-custom="a b"`, h["cache-control"])
+
+	// Test: Empty directives remove the header
+	h.SetCacheControl(NewCacheControl())
+	_, ok := h.Get("Cache-Control")
+	assert.False(t, ok)
+}
@@ -86,3 +86,67 @@ func invalidToken(r rune) bool {
 	}
 	return true
 }
+
+// IsToken reports whether s is a non-empty RFC 9110 token.
+func IsToken(s string) bool {
+	return s != "" && strings.IndexFunc(s, invalidToken) == -1
+}
+
+// SplitQuoted splits s on sep, ignoring separators that appear inside
+// double-quoted strings.
+func SplitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	inQuotes := false
+	escaped := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Quote returns s as a quoted-string, escaping quotes and backslashes.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote removes the surrounding quotes and backslash escapes from a
+// quoted-string. Values that are not quoted are returned unchanged.
+func Unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, "\"") {
+		return "", errors.New("unterminated quoted string")
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s[1 : len(s)-1] {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
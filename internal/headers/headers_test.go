@@ -81,3 +81,31 @@ func TestHeadersParse(t *testing.T) {
 	assert.True(t, done)
 	assert.Equal(t, "jonathan-loves-cpp, lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["set-person"])
 }
+
+func TestTokenList(t *testing.T) {
+	// Test: Whitespace and empty elements
+	assert.Equal(t, []string{"gzip", "chunked"}, ParseTokenList(" gzip ,, chunked, "))
+
+	// Test: Quoted commas do not split
+	assert.Equal(t, []string{`a="b, c"`, "d"}, ParseTokenList(`a="b, c", d`))
+
+	// Test: Case insensitive token lookup across repeated headers
+	headers := NewHeaders()
+	headers.Set("Connection", "keep-alive")
+	headers.Set("Connection", "Upgrade")
+	assert.True(t, headers.HasToken("connection", "upgrade"))
+	assert.False(t, headers.HasToken("Connection", "close"))
+	assert.Nil(t, headers.Tokens("Missing"))
+}
+
+func TestStripHopByHop(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("Connection", "X-Session, close")
+	headers.Set("X-Session", "abc")
+	headers.Set("Keep-Alive", "timeout=5")
+	headers.Set("Transfer-Encoding", "chunked")
+	headers.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	headers.Set("Content-Type", "text/plain")
+	StripHopByHop(headers)
+	assert.Equal(t, Headers{"content-type": "text/plain"}, headers)
+}
@@ -0,0 +1,66 @@
+package headers
+
+import (
+	"strings"
+)
+
+// hopByHopHeaders are the fields that only apply to a single connection and
+// must never be relayed by a proxy, whether or not they are listed in
+// Connection.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ParseTokenList splits a comma-separated list header value into its
+// elements, trimming whitespace and dropping empty elements. Commas inside
+// quoted strings do not split.
+func ParseTokenList(value string) []string {
+	tokens := []string{}
+	for _, token := range SplitQuoted(value, ',') {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Tokens returns the list elements of the header named key.
+func (h Headers) Tokens(key string) []string {
+	value, ok := h.Get(key)
+	if !ok {
+		return nil
+	}
+	return ParseTokenList(value)
+}
+
+// HasToken reports whether the list header named key contains token,
+// compared case-insensitively.
+func (h Headers) HasToken(key, token string) bool {
+	for _, t := range h.Tokens(key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripHopByHop removes the standard hop-by-hop headers and every header
+// named in the Connection header from h, so that it can be relayed to the
+// next hop.
+func StripHopByHop(h Headers) {
+	for _, name := range h.Tokens("Connection") {
+		h.Delete(name)
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
@@ -0,0 +1,29 @@
+package request
+
+import (
+	"errors"
+)
+
+// Errors returned by RequestFromReader, wrapped with details, so callers can
+// choose the response to send for a request that could not be parsed.
+var (
+	// ErrNoRequest means the connection was closed before any part of a
+	// request arrived.
+	ErrNoRequest            = errors.New("connection closed before a request was sent")
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrUnsupportedVersion   = errors.New("unsupported HTTP version")
+	ErrMalformedHeader      = errors.New("malformed header")
+	ErrIncompleteRequest    = errors.New("connection closed mid-request")
+	ErrInvalidContentLength = errors.New("invalid Content-Length")
+	// ErrUnsupportedTransferEncoding means the request body is framed with
+	// a transfer coding, which the parser does not decode.
+	ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+	ErrHeaderTooLarge              = errors.New("request headers too large")
+	ErrBodyTooLarge                = errors.New("request body too large")
+	// ErrInvalidHost means the Host header is missing from an HTTP/1.1
+	// request, repeated or malformed.
+	ErrInvalidHost = errors.New("invalid Host header")
+	// ErrRequestTimeout means a read deadline passed after part of a
+	// request had arrived.
+	ErrRequestTimeout = errors.New("timed out reading request")
+)
@@ -0,0 +1,158 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateHost checks the Host header the way servers must: an HTTP/1.1
+// request has to carry exactly one, and a Host given in any version must be
+// a host name or IP address with an optional port. Repeated Host fields
+// show up as a comma-separated value, since header fields are merged when
+// parsed. For an absolute-form target such as "http://example.com/", the
+// target's authority is checked in place of the Host value, which it
+// overrides.
+func (r *Request) ValidateHost() error {
+	host, ok := r.Headers.Get("Host")
+	if !ok {
+		if r.RequestLine.HttpVersion == "1.1" {
+			return fmt.Errorf("%w: missing Host header", ErrInvalidHost)
+		}
+	} else if strings.Contains(host, ",") {
+		return fmt.Errorf("%w: more than one Host header", ErrInvalidHost)
+	}
+	if authority, ok := targetAuthority(r.RequestLine.RequestTarget); ok {
+		if authority == "" || !validHost(authority) {
+			return fmt.Errorf("%w: request target authority %q", ErrInvalidHost, authority)
+		}
+		return nil
+	}
+	if !validHost(host) {
+		return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+	return nil
+}
+
+// UseOriginForm rewrites an absolute-form request target such as
+// "http://example.com/path?q" to the origin form "/path?q" that handlers and
+// routers expect, replacing the Host header with the target's authority as
+// RFC 9112 requires. Other targets are left alone. Call it after
+// ValidateHost.
+func (r *Request) UseOriginForm() {
+	authority, ok := targetAuthority(r.RequestLine.RequestTarget)
+	if !ok {
+		return
+	}
+	_, rest, _ := strings.Cut(r.RequestLine.RequestTarget, "://")
+	rest = strings.TrimPrefix(rest, authority)
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	r.RequestLine.RequestTarget = rest
+	r.Headers.Replace("Host", authority)
+}
+
+// Host returns the host name the request is addressed to, lowercased and
+// without a port or trailing dot, or "" if it has none. The authority of an
+// absolute-form target takes precedence over the Host header.
+func (r *Request) Host() string {
+	host, ok := targetAuthority(r.RequestLine.RequestTarget)
+	if !ok {
+		host, _ = r.Headers.Get("Host")
+	}
+	name, _ := splitHostPort(host)
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// ValidHostName reports whether name is a host name or bracketed IPv6
+// literal, without a port, that a Host header may carry. Registered names
+// may use letters, digits, percent-encoding and the other characters URIs
+// allow in them, except ',' and '*', which are left out so that repeated
+// Host fields and host name patterns are not mistaken for names. Labels
+// cannot be empty, apart from a trailing dot.
+func ValidHostName(name string) bool {
+	if literal, ok := strings.CutPrefix(name, "["); ok {
+		literal, ok = strings.CutSuffix(literal, "]")
+		return ok && literal != "" && !strings.ContainsFunc(literal, func(r rune) bool {
+			return !isHex(r) && r != ':' && r != '.'
+		})
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return false
+	}
+	return !strings.ContainsFunc(name, func(r rune) bool { return !isRegNameChar(r) })
+}
+
+// validHost reports whether host is a uri-host with an optional port as
+// allowed in a Host header. An empty host is valid for targets without an
+// authority.
+func validHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	name, port := splitHostPort(host)
+	if !ValidHostName(name) {
+		return false
+	}
+	if port == "" {
+		return true
+	}
+	digits, ok := strings.CutPrefix(port, ":")
+	return ok && !strings.ContainsFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+}
+
+// splitHostPort splits host into the name and the port with its leading
+// colon, keeping the brackets of an IPv6 literal in the name.
+func splitHostPort(host string) (string, string) {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.IndexByte(host, ']'); end != -1 {
+			return host[:end+1], host[end+1:]
+		}
+		return host, ""
+	}
+	if i := strings.LastIndexByte(host, ':'); i != -1 {
+		return host[:i], host[i:]
+	}
+	return host, ""
+}
+
+// targetAuthority returns the authority of an absolute-form request target
+// such as "http://example.com:8080/path", reporting false for targets in
+// any other form.
+func targetAuthority(target string) (string, bool) {
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok || !validScheme(scheme) {
+		return "", false
+	}
+	if end := strings.IndexAny(rest, "/?#"); end != -1 {
+		rest = rest[:end]
+	}
+	return rest, true
+}
+
+// validScheme reports whether scheme is a URI scheme: a letter followed by
+// letters, digits, '+', '-' or '.'.
+func validScheme(scheme string) bool {
+	if scheme == "" || !(scheme[0] >= 'a' && scheme[0] <= 'z' || scheme[0] >= 'A' && scheme[0] <= 'Z') {
+		return false
+	}
+	return !strings.ContainsFunc(scheme, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '+' && r != '-' && r != '.'
+	})
+}
+
+// isRegNameChar reports whether r may appear in a registered name, leaving
+// out the comma so repeated Host fields are not mistaken for one, and the
+// asterisk, which host name patterns use as a wildcard.
+func isRegNameChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-._~%!$&'()+;=", r)
+}
+
+func isHex(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
@@ -0,0 +1,168 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	// DefaultMaxHeaderBytes limits the request line and headers of a
+	// request when no other limit is set.
+	DefaultMaxHeaderBytes = 1 << 20
+	// DefaultMaxBodyBytes limits the declared body length of a request when
+	// no other limit is set.
+	DefaultMaxBodyBytes = 10 << 20
+)
+
+const bufferSize = 8
+
+// Reader reads successive requests from a stream such as a keep-alive
+// connection. Bytes read past the end of one request are kept for the
+// next.
+type Reader struct {
+	// MaxHeaderBytes limits the size of the request line and headers.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the Content-Length a request may declare.
+	MaxBodyBytes int64
+	// OnHeaders, if set, is called once the request line and headers of a
+	// request have been read, before the rest of its body is read from the
+	// stream. Servers use it to switch from a header to a body read
+	// deadline and to answer Expect: 100-continue.
+	OnHeaders func(req *Request)
+
+	src         io.Reader
+	buffer      []byte
+	readToIndex int
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		MaxHeaderBytes: DefaultMaxHeaderBytes,
+		MaxBodyBytes:   DefaultMaxBodyBytes,
+		src:            src,
+		buffer:         make([]byte, bufferSize),
+	}
+}
+
+// Buffered reports whether bytes of a following request have already been
+// read from the stream.
+func (rr *Reader) Buffered() bool {
+	return rr.readToIndex > 0
+}
+
+// TakeBuffered returns the bytes read past the last request and forgets
+// them, for handing the stream over to another protocol.
+func (rr *Reader) TakeBuffered() []byte {
+	buffered := append([]byte(nil), rr.buffer[:rr.readToIndex]...)
+	rr.readToIndex = 0
+	return buffered
+}
+
+// WaitForRequest blocks until the first bytes of the next request are
+// available, without parsing them. It returns ErrNoRequest if the stream
+// ends first.
+func (rr *Reader) WaitForRequest() error {
+	if rr.readToIndex > 0 {
+		return nil
+	}
+	for {
+		numBytesRead, err := rr.src.Read(rr.buffer)
+		rr.readToIndex += numBytesRead
+		if numBytesRead > 0 {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return ErrNoRequest
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadRequest reads the next request from the stream.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	headersRead := false
+	req := Request{
+		state:   requestStateInitialized,
+		Headers: headers.NewHeaders(),
+		Body:    make([]byte, 0),
+	}
+	for {
+		numBytesParsed, err := req.parse(rr.buffer[:rr.readToIndex])
+		if err != nil {
+			return &req, err
+		}
+		copy(rr.buffer, rr.buffer[numBytesParsed:rr.readToIndex])
+		rr.readToIndex -= numBytesParsed
+
+		if err := rr.checkLimits(&req); err != nil {
+			return &req, err
+		}
+		if !headersRead && req.state >= requestStateParsingBody {
+			headersRead = true
+			if rr.OnHeaders != nil {
+				rr.OnHeaders(&req)
+			}
+		}
+		if req.state == requestStateDone {
+			return &req, nil
+		}
+
+		if rr.readToIndex == len(rr.buffer) {
+			newBuffer := make([]byte, 2*len(rr.buffer))
+			copy(newBuffer, rr.buffer)
+			rr.buffer = newBuffer
+		}
+
+		numBytesRead, err := rr.src.Read(rr.buffer[rr.readToIndex:])
+		rr.readToIndex += numBytesRead
+		if numBytesRead > 0 {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			switch {
+			case req.state == requestStateParsingHeaders:
+				return nil, fmt.Errorf("%w: missing end of headers", ErrIncompleteRequest)
+			case req.state == requestStateParsingBody:
+				return nil, fmt.Errorf("%w: Received partial body content", ErrIncompleteRequest)
+			case rr.readToIndex > 0:
+				return nil, fmt.Errorf("%w: missing end of request line", ErrIncompleteRequest)
+			}
+			return nil, ErrNoRequest
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && (req.state != requestStateInitialized || rr.readToIndex > 0) {
+			return nil, fmt.Errorf("%w: %w", ErrRequestTimeout, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (rr *Reader) checkLimits(req *Request) error {
+	headerBytes := req.headerBytes
+	if req.state < requestStateParsingBody {
+		// the unparsed bytes are part of a header line still arriving
+		headerBytes += rr.readToIndex
+	}
+	if rr.MaxHeaderBytes > 0 && headerBytes > rr.MaxHeaderBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrHeaderTooLarge, rr.MaxHeaderBytes)
+	}
+	if req.state < requestStateParsingBody {
+		return nil
+	}
+	contentLengthStr, ok := req.Headers.Get("Content-Length")
+	if !ok || rr.MaxBodyBytes <= 0 {
+		return nil
+	}
+	contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+	if err == nil && contentLength > rr.MaxBodyBytes {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, contentLength, rr.MaxBodyBytes)
+	}
+	return nil
+}
@@ -23,7 +23,21 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       requestState
+	// RemoteAddr is the address of the peer that sent the request.
+	RemoteAddr string
+	// ClientAddr is the address of the original client once forwarding
+	// headers from trusted proxies have been taken into account.
+	ClientAddr string
+	// Params holds the path parameters a router matched in the request
+	// target, keyed by name.
+	Params map[string]string
+	// MountPath is the prefix a router stripped from the request target
+	// before passing the request to a handler mounted below it, such as
+	// "/api". Handlers use it to build links to their own routes.
+	MountPath string
+	state     requestState
+	// headerBytes counts the bytes of the request line and headers
+	headerBytes int
 }
 
 type RequestLine struct {
@@ -33,50 +47,11 @@ type RequestLine struct {
 }
 
 const crlf = "\r\n"
-const bufferSize = 8
 
+// RequestFromReader reads a single request from reader with the default
+// limits.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	req := Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
-	}
-	buffer := make([]byte, bufferSize)
-	readToIndex := 0
-	for req.state != requestStateDone {
-		if readToIndex == len(buffer) {
-			newBuffer := make([]byte, 2*len(buffer))
-			copy(newBuffer, buffer)
-			buffer = newBuffer
-
-		}
-
-		numBytesRead, err := reader.Read(buffer[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				switch req.state {
-				case requestStateParsingHeaders:
-					return nil, errors.New("missing end of headers")
-				case requestStateParsingBody:
-					return nil, errors.New("Received partial body content")
-				}
-				req.state = requestStateDone
-				break
-			}
-			return nil, err
-		}
-		readToIndex += numBytesRead
-
-		numBytesParsed, err := req.parse(buffer[:readToIndex])
-		if err != nil {
-			return &req, err
-		}
-
-		copy(buffer, buffer[numBytesParsed:])
-		readToIndex -= numBytesParsed
-	}
-
-	return &req, nil
+	return NewReader(reader).ReadRequest()
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -95,23 +70,23 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 func requestLineFromString(str string) (*RequestLine, error) {
 	parts := strings.Split(str, " ")
 	if len(parts) != 3 {
-		return nil, errors.New("Too many space separated parts in the request line.")
+		return nil, fmt.Errorf("%w: Too many space separated parts in the request line.", ErrMalformedRequestLine)
 	}
 
 	method := parts[0]
 
 	if strings.ContainsFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) {
-		return nil, errors.New("Method contains non capital alphabetic characters.")
+		return nil, fmt.Errorf("%w: Method contains non capital alphabetic characters.", ErrMalformedRequestLine)
 	}
 
 	httpParts := strings.Split(parts[2], "/")
-	if len(httpParts) != 2 {
-		return nil, errors.New("Invalid HTTP version format")
+	if len(httpParts) != 2 || httpParts[0] != "HTTP" {
+		return nil, fmt.Errorf("%w: Invalid HTTP version format", ErrMalformedRequestLine)
 	}
 
 	version := httpParts[1]
-	if version != "1.1" {
-		return nil, errors.New("Unsupported HTTP version")
+	if version != "1.1" && version != "1.0" {
+		return nil, fmt.Errorf("%w: HTTP/%s", ErrUnsupportedVersion, version)
 	}
 
 	return &RequestLine{
@@ -145,17 +120,27 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			r.RequestLine = *requestLine
 			r.state = requestStateParsingHeaders
 		}
+		r.headerBytes += bytesRead
 		return bytesRead, nil
 	case requestStateParsingHeaders:
 		bytesRead, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("%w: %s", ErrMalformedHeader, err)
 		}
 		if done {
 			r.state = requestStateParsingBody
 		}
+		r.headerBytes += bytesRead
 		return bytesRead, nil
 	case requestStateParsingBody:
+		// transfer codings are not decoded, so a body framed by one cannot be
+		// delimited and must not be mistaken for the next request
+		if coding, ok := r.Headers.Get("Transfer-Encoding"); ok {
+			if _, ok := r.Headers.Get("Content-Length"); ok {
+				return 0, fmt.Errorf("%w: sent together with Transfer-Encoding", ErrInvalidContentLength)
+			}
+			return 0, fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, coding)
+		}
 		contentLengthStr, ok := r.Headers.Get("Content-Length")
 		if !ok {
 			// assume that if no content-length header is present, there is no body
@@ -164,15 +149,20 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		contentLength, err := strconv.Atoi(contentLengthStr)
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, fmt.Errorf("%w: %s", ErrInvalidContentLength, err)
+		}
+		if contentLength < 0 {
+			return 0, fmt.Errorf("%w: %d", ErrInvalidContentLength, contentLength)
 		}
 
-		r.Body = append(r.Body, data...)
-		bodyLen := len(r.Body)
-		if bodyLen > contentLength {
-			return len(data), errors.New("error: body length is greater thancontent length")
+		// only take this request's body, anything after it belongs to the
+		// next request on the connection
+		remaining := contentLength - len(r.Body)
+		if len(data) > remaining {
+			data = data[:remaining]
 		}
-		if bodyLen == contentLength {
+		r.Body = append(r.Body, data...)
+		if len(r.Body) == contentLength {
 			r.state = requestStateDone
 		}
 		return len(data), nil
@@ -182,3 +172,17 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return 0, errors.New("unknown state")
 	}
 }
+
+// ExpectsContinue reports whether the client of a request whose headers have
+// been read is waiting for a 100 Continue response before sending the rest
+// of its body.
+func (r *Request) ExpectsContinue() bool {
+	if r.state != requestStateParsingBody || r.RequestLine.HttpVersion != "1.1" {
+		return false
+	}
+	if expect, ok := r.Headers.Get("Expect"); !ok || !strings.EqualFold(expect, "100-continue") {
+		return false
+	}
+	contentLength, ok := r.Headers.Get("Content-Length")
+	return ok && contentLength != "0"
+}
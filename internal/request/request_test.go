@@ -1,11 +1,11 @@
 package request
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
-	//"strings"
-	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -101,6 +101,16 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err)
 	//reader.PrintLog()
 
+	// Test: HTTP/1.0 Request line
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.0\r\nUser-Agent: curl/7.81.0\r\n\r\n",
+		numBytesPerRead: 5,
+		readCount:       0,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
 	// Test: Invalid version in Request line
 	reader = &chunkReader{
 		data:            "GET / HTTP/3.0\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -237,3 +247,151 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestParseErrors(t *testing.T) {
+	// Test: Connection closed before anything was sent
+	_, err := RequestFromReader(&chunkReader{data: "", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrNoRequest)
+
+	// Test: Connection closed mid request line
+	_, err = RequestFromReader(&chunkReader{data: "GET / HT", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrIncompleteRequest)
+
+	// Test: Malformed request line
+	_, err = RequestFromReader(&chunkReader{data: "GET / FTP/1.1\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: Unsupported version
+	_, err = RequestFromReader(&chunkReader{data: "GET / HTTP/2.0\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// Test: Malformed header
+	_, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrMalformedHeader)
+
+	// Test: Negative Content-Length
+	_, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrInvalidContentLength)
+
+	// Test: Transfer codings are rejected rather than left in the stream
+	_, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+	_, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n", numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrInvalidContentLength)
+}
+
+func TestReader(t *testing.T) {
+	// Test: Pipelined requests on one stream
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrNoRequest)
+
+	// Test: Header limit
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Padding: " + strings.Repeat("a", 100) + "\r\n\r\n",
+		numBytesPerRead: 1000,
+	})
+	reader.MaxHeaderBytes = 64
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Body limit
+	reader = NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n0123",
+		numBytesPerRead: 1000,
+	})
+	reader.MaxBodyBytes = 10
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Waiting for a request and the headers hook
+	reader = NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nbody",
+		numBytesPerRead: 5,
+	})
+	var headersSeen string
+	reader.OnHeaders = func(req *Request) {
+		headersSeen, _ = req.Headers.Get("Content-Length")
+	}
+	require.NoError(t, reader.WaitForRequest())
+	assert.True(t, reader.Buffered())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "4", headersSeen)
+	assert.Equal(t, "body", string(r.Body))
+	require.ErrorIs(t, reader.WaitForRequest(), ErrNoRequest)
+}
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+		host  string
+	}{
+		{"GET / HTTP/1.1\r\nHost: Example.COM:8080\r\n\r\n", true, "example.com"},
+		{"GET / HTTP/1.1\r\nHost: [2001:db8::1]:443\r\n\r\n", true, "[2001:db8::1]"},
+		{"GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n", true, "192.0.2.1"},
+		{"GET / HTTP/1.1\r\nHost: example.com.\r\n\r\n", true, "example.com"},
+		{"GET / HTTP/1.1\r\nHost: \r\n\r\n", true, ""},
+		{"GET / HTTP/1.0\r\n\r\n", true, ""},
+		{"GET / HTTP/1.1\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n", false, ""},
+		{"GET / HTTP/1.0\r\nHost: a.test\r\nHost: b.test\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: user@example.com\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: example.com:http\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: [::1\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: my_host~1.example\r\n\r\n", true, "my_host~1.example"},
+		{"GET / HTTP/1.1\r\nHost: a..example\r\n\r\n", false, ""},
+		{"GET / HTTP/1.1\r\nHost: *.example\r\n\r\n", false, ""},
+		// the authority of an absolute-form target overrides Host
+		{"GET http://Example.ORG:8080/x?y HTTP/1.1\r\nHost: example.com\r\n\r\n", true, "example.org"},
+		{"GET https://[::1]/ HTTP/1.1\r\nHost: bad host\r\n\r\n", true, "[::1]"},
+		{"GET http://user@example.org/ HTTP/1.1\r\nHost: example.org\r\n\r\n", false, ""},
+		{"GET http:///x HTTP/1.1\r\nHost: example.org\r\n\r\n", false, ""},
+		{"GET http://example.org/ HTTP/1.1\r\n\r\n", false, ""},
+	}
+	for _, tt := range tests {
+		r, err := RequestFromReader(strings.NewReader(tt.raw))
+		require.NoError(t, err)
+		err = r.ValidateHost()
+		if !tt.valid {
+			require.ErrorIs(t, err, ErrInvalidHost, tt.raw)
+			continue
+		}
+		require.NoError(t, err, tt.raw)
+		assert.Equal(t, tt.host, r.Host(), tt.raw)
+	}
+}
+
+func TestUseOriginForm(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+		host   string
+	}{
+		{"http://Example.org:8080/x?y=1", "/x?y=1", "Example.org:8080"},
+		{"http://example.org", "/", "example.org"},
+		{"http://example.org?y=1", "/?y=1", "example.org"},
+		{"/plain?y=1", "/plain?y=1", "example.com"},
+		{"*", "*", "example.com"},
+	}
+	for _, tt := range tests {
+		r, err := RequestFromReader(strings.NewReader("GET " + tt.target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		require.NoError(t, err)
+		r.UseOriginForm()
+		assert.Equal(t, tt.want, r.RequestLine.RequestTarget, tt.target)
+		host, _ := r.Headers.Get("Host")
+		assert.Equal(t, tt.host, host, tt.target)
+	}
+}
@@ -0,0 +1,200 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"strconv"
+)
+
+// DefaultBufferSize is how many body bytes a Writer holds back to compute a
+// Content-Length before switching to chunked encoding.
+const DefaultBufferSize = 4096
+
+// Header returns the headers that will be sent when the writer sends headers
+// on the handler's behalf, either because Write was called before
+// WriteHeaders or because the handler returned without writing any. Changes
+// after that point have no effect.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+// Write writes p to the body, choosing the framing automatically when
+// headers have not been written yet. Small bodies are buffered so that a
+// Content-Length can be sent, while bodies larger than the buffer size are
+// sent chunked unless the handler set a Content-Length in Header. After
+// explicit WriteHeaders, each call is sent as a chunk if the headers
+// declared chunked transfer coding, or as is otherwise.
+func (w *Writer) Write(p []byte) (int, error) {
+	switch w.state {
+	case writerStateStatusLine, writerStateHeaders:
+		w.statusPending = w.state == writerStateStatusLine
+		if w.statusPending {
+			w.status = StatusOK
+		}
+		w.state = writerStateBuffering
+		return w.Write(p)
+	case writerStateBuffering:
+		w.buf = append(w.buf, p...)
+		if len(w.buf) <= w.bufferSize {
+			return len(p), nil
+		}
+		if err := w.sendHeaders(-1); err != nil {
+			return 0, err
+		}
+		buffered := w.buf
+		w.buf = nil
+		if _, err := w.writeBodyFramed(buffered); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case writerStateBody:
+		return w.writeBodyFramed(p)
+	case writerStateHijacked:
+		return 0, ErrHijacked
+	default:
+		return 0, fmt.Errorf("cannot write body in state %d", w.state)
+	}
+}
+
+// Written reports whether the final status line has already been sent, after
+// which the response can no longer be replaced.
+func (w *Writer) Written() bool {
+	switch w.state {
+	case writerStateStatusLine:
+		return false
+	case writerStateBuffering:
+		return !w.statusPending
+	default:
+		return true
+	}
+}
+
+// Reset discards the headers and buffered body of a response that has not
+// been written yet, so that a different response can be sent instead.
+func (w *Writer) Reset() error {
+	if w.Written() {
+		return errors.New("cannot reset a response that has already been written")
+	}
+	w.state = writerStateStatusLine
+	w.statusPending = false
+	w.header = headers.NewHeaders()
+	w.buf = nil
+	return nil
+}
+
+// Finish completes the response once the handler is done with it. Headers
+// that were never sent are sent with a Content-Length matching whatever was
+// buffered, an unterminated chunked body is terminated, and the underlying
+// stream is flushed. If the body is shorter or longer than its declared
+// Content-Length the connection is marked to be closed and an error
+// describing the mismatch is returned. Finish does nothing after Hijack.
+func (w *Writer) Finish() error {
+	if w.state == writerStateHijacked {
+		return nil
+	}
+	var err error
+	switch w.state {
+	case writerStateStatusLine, writerStateHeaders:
+		w.statusPending = w.state == writerStateStatusLine
+		if w.statusPending {
+			w.status = StatusOK
+		}
+		err = w.sendHeaders(0)
+	case writerStateBuffering:
+		err = w.sendHeaders(len(w.buf))
+		if err == nil {
+			// the buffered body goes through the same Content-Length checks
+			// as one written after the headers
+			if _, err = w.writeBodyFramed(w.buf); err != nil {
+				w.closeAfter = true
+			}
+		}
+		w.buf = nil
+		if err == nil {
+			err = w.endBody()
+		}
+	case writerStateBody:
+		err = w.endBody()
+	case writerStateTrailers:
+		_, err = w.body.Write([]byte(crlf))
+	}
+	w.state = writerStateComplete
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	for _, fn := range w.onFinish {
+		fn()
+	}
+	w.onFinish = nil
+	return err
+}
+
+// endBody terminates a chunked body, or checks that a body with a declared
+// Content-Length was sent in full.
+func (w *Writer) endBody() error {
+	if w.chunked {
+		_, err := w.WriteChunkedBodyDone(false)
+		return err
+	}
+	if w.contentLength >= 0 && w.written < w.contentLength && !w.omitBody {
+		w.closeAfter = true
+		return fmt.Errorf("response body ended after %d of %d declared bytes", w.written, w.contentLength)
+	}
+	return nil
+}
+
+// sendHeaders writes any pending status line and the headers from Header,
+// adding framing headers. A contentLength of -1 means the length is unknown
+// and the body is sent chunked, unless the handler set a Content-Length.
+func (w *Writer) sendHeaders(contentLength int) error {
+	if w.statusPending {
+		w.statusPending = false
+		w.state = writerStateStatusLine
+		if err := w.WriteStatusLine(w.status); err != nil {
+			return err
+		}
+	}
+	w.state = writerStateHeaders
+
+	h := w.header
+	_, hasLength := h.Get("Content-Length")
+	switch {
+	case !bodyAllowed(w.status):
+	case hasLength, h.HasToken("Transfer-Encoding", "chunked"):
+	case contentLength >= 0:
+		h.Replace("Content-Length", strconv.Itoa(contentLength))
+	case w.version == "1.0":
+		// HTTP/1.0 has no chunked coding, so the body runs until close
+		w.closeAfter = true
+	default:
+		h.Replace("Transfer-Encoding", "chunked")
+	}
+	return w.WriteHeaders(h)
+}
+
+func (w *Writer) writeBodyFramed(p []byte) (int, error) {
+	if !bodyAllowed(w.status) {
+		return len(p), nil
+	}
+	if !w.chunked {
+		return w.WriteBody(p)
+	}
+	if len(p) == 0 {
+		// a zero length chunk would end the body
+		return 0, nil
+	}
+	if _, err := w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// bodyAllowed reports whether a response with the given status may include
+// a body.
+func bodyAllowed(status StatusCode) bool {
+	if status >= 100 && status < 200 {
+		return false
+	}
+	return status != StatusNoContent && status != StatusNotModified
+}
@@ -0,0 +1,50 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrNotHijackable is returned by Hijack when the writer is not over a
+	// connection a server can hand off.
+	ErrNotHijackable = errors.New("response writer does not support hijacking")
+	// ErrHijacked is returned by writes to a Writer whose connection has
+	// been hijacked.
+	ErrHijacked = errors.New("connection has been hijacked")
+)
+
+// Hijacker hands the connection under a Writer over to a handler. Servers
+// install one with SetHijacker.
+type Hijacker func() (net.Conn, *bufio.ReadWriter, error)
+
+// SetHijacker lets handlers take over the connection w writes to through
+// Hijack.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes over the connection, for protocols such as WebSocket that
+// speak something other than HTTP once the request has been read. The
+// returned reader holds any bytes the client sent after the request. The
+// caller becomes responsible for closing the connection, and the Writer can
+// no longer be used. Hijack fails once the response has been started.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.state == writerStateHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if w.Written() {
+		return nil, nil, errors.New("cannot hijack a connection after the response was started")
+	}
+	conn, rw, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.state = writerStateHijacked
+	w.buf = nil
+	return conn, rw, nil
+}
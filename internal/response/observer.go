@@ -0,0 +1,43 @@
+package response
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+)
+
+// Observer records what a handler sends through a Writer, for middleware
+// such as access logs and metrics that run code around a handler and need
+// to know how it answered.
+type Observer struct {
+	w      *Writer
+	status StatusCode
+	header headers.Headers
+}
+
+// Observe starts recording the response sent through w. It must be called
+// before the handler writes its headers.
+func Observe(w *Writer) *Observer {
+	o := &Observer{w: w}
+	w.OnHeaders(func(status StatusCode, h headers.Headers) {
+		o.status = status
+		o.header = h
+	})
+	return o
+}
+
+// Status returns the status code of the final response, or 0 if its headers
+// have not been sent.
+func (o *Observer) Status() StatusCode {
+	return o.status
+}
+
+// Header returns the headers of the final response as they were sent, or
+// nil if they have not been sent.
+func (o *Observer) Header() headers.Headers {
+	return o.header
+}
+
+// BytesWritten returns how many body bytes have been sent, not counting
+// chunk framing.
+func (o *Observer) BytesWritten() int64 {
+	return o.w.written
+}
@@ -1,51 +1,128 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jmservic/httpfromtcp/internal/headers"
 	"io"
-	"net"
 	"strconv"
+	"strings"
 )
 
 type StatusCode int
 
-const (
-	StatusOK                  = 200
-	StatusBadRequest          = 400
-	StatusInternalServerError = 500
-)
+// ErrContentLength is returned when a body write would exceed the
+// Content-Length declared in the response headers.
+var ErrContentLength = errors.New("response body longer than declared Content-Length")
+
+const crlf = "\r\n"
 
 type writerState int
 
 const (
 	writerStateStatusLine = iota
 	writerStateHeaders
+	// writerStateBuffering means the handler has written body bytes with
+	// Write before sending headers, so the status line and headers are
+	// held back until the framing can be decided.
+	writerStateBuffering
 	writerStateBody
 	writerStateTrailers
 	writerStateComplete
+	// writerStateHijacked means a handler took over the connection.
+	writerStateHijacked
 )
 
+// Writer writes a single HTTP response to an underlying stream such as a
+// net.Conn, a buffer or a compression layer.
 type Writer struct {
-	conn  net.Conn
-	state writerState
+	dst io.Writer
+	// body receives everything after the headers. It is dst, or a discard
+	// writer when responding to a HEAD request.
+	body       io.Writer
+	state      writerState
+	status     StatusCode
+	header     headers.Headers
+	buf        []byte
+	bufferSize int
+	// statusPending is set when buffering started before a status line was
+	// written, so an implicit 200 must precede the headers.
+	statusPending bool
+	// chunked is set once headers declaring chunked transfer coding have
+	// been written, so Write frames each call as a chunk.
+	chunked bool
+	// contentLength is the declared body length, or -1 if the body is not
+	// delimited by a Content-Length.
+	contentLength int64
+	// written counts the body bytes sent, excluding chunk framing
+	written    int64
+	closeAfter bool
+	omitBody   bool
+	version    string
+	defaults   headers.Headers
+	onHeaders  []func(StatusCode, headers.Headers)
+	onFinish   []func()
+	hijacker   Hijacker
+}
+
+// flusher is implemented by destinations that buffer writes, such as
+// bufio.Writer and gzip.Writer.
+type flusher interface {
+	Flush() error
+}
+
+func NewWriter(dst io.Writer) *Writer {
+	return NewWriterSize(dst, DefaultBufferSize)
 }
 
-func NewWriter(conn net.Conn) *Writer {
+// NewWriterSize returns a Writer that buffers up to size bytes written with
+// Write before giving up on a Content-Length and switching to chunked
+// encoding.
+func NewWriterSize(dst io.Writer, size int) *Writer {
 	return &Writer{
-		conn:  conn,
-		state: writerStateStatusLine,
+		dst:           dst,
+		body:          dst,
+		state:         writerStateStatusLine,
+		header:        headers.NewHeaders(),
+		bufferSize:    size,
+		contentLength: -1,
 	}
 }
 
+// Flush sends any data buffered by the underlying stream. It is a no-op if
+// the stream does not buffer.
+func (w *Writer) Flush() error {
+	if f, ok := w.dst.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close flushes and then closes the underlying stream if it can be closed.
+// When the writer is over a connection this closes the connection.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if c, ok := w.dst.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
 	if w.state != writerStateStatusLine {
 		return fmt.Errorf("Attempted to write status line in the wrong state.")
 	}
-	err := WriteStatusLine(w.conn, statusCode)
+	err := WriteStatusLineReason(w.dst, statusCode, reason)
 	if err != nil {
 		return fmt.Errorf("Error writing the HTTP Status line: %w", err)
 	}
+	w.status = statusCode
 	w.state = writerStateHeaders
 	return nil
 }
@@ -54,36 +131,147 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.state != writerStateHeaders {
 		return fmt.Errorf("Attempted to write headers line in the wrong state.")
 	}
-	err := WriteHeaders(w.conn, headers)
+	if len(w.defaults) > 0 || len(w.onHeaders) > 0 {
+		headers = withDefaults(headers, w.defaults)
+	}
+	for _, fn := range w.onHeaders {
+		fn(w.status, headers)
+	}
+	chunked := headers.HasToken("Transfer-Encoding", "chunked")
+	var contentLength int64 = -1
+	if lengthStr, ok := headers.Get("Content-Length"); ok && !chunked && bodyAllowed(w.status) {
+		length, err := strconv.ParseInt(lengthStr, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid Content-Length %q", lengthStr)
+		}
+		contentLength = length
+	}
+	err := WriteHeaders(w.dst, headers)
 	if err != nil {
 		return fmt.Errorf("Error writing HTTP headers: %w", err)
 	}
+	w.chunked = chunked
+	w.contentLength = contentLength
+	if headers.HasToken("Connection", "close") {
+		w.closeAfter = true
+	}
 	w.state = writerStateBody
 	return nil
 }
 
+// OnHeaders registers fn to be called just before the final response
+// headers are written, with the status code and a copy of the headers that
+// fn may modify.
+func (w *Writer) OnHeaders(fn func(status StatusCode, h headers.Headers)) {
+	w.onHeaders = append(w.onHeaders, fn)
+}
+
+// OnFinish registers fn to be called once Finish has completed the
+// response.
+func (w *Writer) OnFinish(fn func()) {
+	w.onFinish = append(w.onFinish, fn)
+}
+
+// SetDefaultHeaders sets fields to add to the final response headers when
+// the handler has not set them itself, such as Date and Server.
+func (w *Writer) SetDefaultHeaders(h headers.Headers) {
+	w.defaults = h
+}
+
+func withDefaults(h, defaults headers.Headers) headers.Headers {
+	merged := headers.NewHeaders()
+	for key, val := range defaults {
+		merged.Replace(key, val)
+	}
+	for key, val := range h {
+		merged.Replace(key, val)
+	}
+	return merged
+}
+
+// WriteBody writes p to the body as is, without any chunk framing. It may
+// be called any number of times, but writing more than the declared
+// Content-Length fails with ErrContentLength and writes nothing.
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.state)
 	}
-	w.state = writerStateComplete
-	return w.conn.Write(p)
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	n, err := w.body.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// SetMethod tells the writer the method of the request being answered. For
+// HEAD requests, headers are written as they would be for GET, including
+// any computed Content-Length, but body bytes are discarded.
+func (w *Writer) SetMethod(method string) {
+	w.omitBody = method == "HEAD"
+	if w.omitBody {
+		w.body = io.Discard
+	} else {
+		w.body = w.dst
+	}
+}
+
+// SetVersion tells the writer the HTTP version of the request being
+// answered, such as "1.1". HTTP/1.0 clients cannot receive interim
+// responses or chunked bodies.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// WriteInformational sends an interim 1xx response, such as 103 Early Hints
+// with Link headers, ahead of the final response. It may be called any
+// number of times before the final status line. h may be nil. The server
+// answers Expect: 100-continue itself before reading the request body, so
+// handlers should not send 100 Continue.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if w.state != writerStateStatusLine {
+		return fmt.Errorf("cannot write an informational response in state %d", w.state)
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("%d is not an informational status code", statusCode)
+	}
+	if w.version == "1.0" {
+		return errors.New("informational responses cannot be sent to HTTP/1.0 clients")
+	}
+	if err := WriteStatusLine(w.dst, statusCode); err != nil {
+		return fmt.Errorf("Error writing the HTTP Status line: %w", err)
+	}
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	if err := WriteHeaders(w.dst, h); err != nil {
+		return fmt.Errorf("Error writing HTTP headers: %w", err)
+	}
+	// interim responses are only useful if the client sees them right away
+	return w.Flush()
+}
+
+// ShouldClose reports whether the connection must be closed after this
+// response because the client can no longer tell where it ends.
+func (w *Writer) ShouldClose() bool {
+	return w.closeAfter
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.state)
 	}
-	writtenBytes, err := fmt.Fprintf(w.conn, "%X\r\n", len(p))
+	writtenBytes, err := fmt.Fprintf(w.body, "%X\r\n", len(p))
 	if err != nil {
 		return writtenBytes, err
 	}
-	n, err := w.conn.Write(p)
+	n, err := w.body.Write(p)
 	writtenBytes += n
+	w.written += int64(n)
 	if err != nil {
 		return writtenBytes, err
 	}
-	n, err = w.conn.Write([]byte("\r\n"))
+	n, err = w.body.Write([]byte("\r\n"))
 	if err != nil {
 		return writtenBytes, err
 	}
@@ -103,7 +291,7 @@ func (w *Writer) WriteChunkedBodyDone(hasTrailers bool) (int, error) {
 		line = []byte("0\r\n")
 		nextState = writerStateTrailers
 	}
-	n, err := w.conn.Write(line)
+	n, err := w.body.Write(line)
 	if err != nil {
 		return n, err
 	}
@@ -116,7 +304,7 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != writerStateTrailers {
 		return fmt.Errorf("cannot write trailers in state %d", w.state)
 	}
-	err := WriteHeaders(w.conn, h)
+	err := WriteHeaders(w.body, h)
 	if err != nil {
 		return fmt.Errorf("Error writing HTTP trailers: %w", err)
 	}
@@ -126,19 +314,19 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 }
 
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	var err error
+	return WriteStatusLineReason(w, statusCode, StatusText(statusCode))
+}
 
-	switch statusCode {
-	case StatusOK:
-		_, err = w.Write([]byte("HTTP/1.1 200 OK \r\n"))
-	case StatusBadRequest:
-		_, err = w.Write([]byte("HTTP/1.1 400 Bad Request \r\n"))
-	case StatusInternalServerError:
-		_, err = w.Write([]byte("HTTP/1.1 500 Internal Server Error \r\n"))
-	default:
-		_, err = fmt.Fprintf(w, "HTTP/1.1 %v \r\n", statusCode)
+// WriteStatusLineReason writes a status line with a custom reason phrase,
+// which may be empty.
+func WriteStatusLineReason(w io.Writer, statusCode StatusCode, reason string) error {
+	if !statusCode.Valid() {
+		return fmt.Errorf("invalid status code %d", statusCode)
 	}
-
+	if strings.ContainsAny(reason, "\r\n") {
+		return fmt.Errorf("invalid reason phrase %q", reason)
+	}
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", statusCode, reason)
 	return err
 }
 
@@ -157,10 +345,12 @@ func WriteHeaders(w io.Writer, headers headers.Headers) error {
 	return nil
 }
 
+// GetDefaultHeaders returns the headers for a response with a body of
+// contentLen bytes. Fields such as Content-Type and Date come from the
+// server's default headers unless the handler sets them, and whether the
+// connection stays open is up to the server.
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	header := headers.NewHeaders()
 	header.Set("Content-Length", strconv.Itoa(contentLen))
-	header.Set("Connection", "close")
-	header.Set("Content-Type", "text/plain")
 	return header
 }
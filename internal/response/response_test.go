@@ -0,0 +1,363 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered status code
+	buf := &bytes.Buffer{}
+	err := WriteStatusLine(buf, StatusNotFound)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buf.String())
+
+	// Test: Unregistered status code keeps the separating space
+	buf.Reset()
+	err = WriteStatusLine(buf, StatusCode(599))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf.Reset()
+	err = WriteStatusLineReason(buf, StatusOK, "Everything Is Fine")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Everything Is Fine\r\n", buf.String())
+
+	// Test: Out of range status codes
+	buf.Reset()
+	err = WriteStatusLine(buf, StatusCode(99))
+	require.Error(t, err)
+	err = WriteStatusLine(buf, StatusCode(1000))
+	require.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Reason phrase with a line break
+	err = WriteStatusLineReason(buf, StatusOK, "OK\r\nX-Injected: 1")
+	require.Error(t, err)
+}
+
+type flushCloseBuffer struct {
+	bytes.Buffer
+	flushed int
+	closed  bool
+}
+
+func (b *flushCloseBuffer) Flush() error {
+	b.flushed++
+	return nil
+}
+
+func (b *flushCloseBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestWriterDestination(t *testing.T) {
+	// Test: Writing a full response into a buffer
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello", buf.String())
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+
+	// Test: Flush and Close reach the destination when supported
+	fc := &flushCloseBuffer{}
+	w = NewWriter(fc)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+	assert.Equal(t, 2, fc.flushed)
+	assert.True(t, fc.closed)
+}
+
+func TestWriterAutoFraming(t *testing.T) {
+	// Test: Small body gets a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String()[:17])
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.Contains(t, buf.String(), "content-type: text/plain\r\n")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello world")))
+
+	// Test: Body larger than the buffer switches to chunked encoding
+	buf.Reset()
+	w = NewWriterSize(buf, 4)
+	require.NoError(t, w.WriteStatusLine(StatusCreated))
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("defg"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 201 Created\r\ntransfer-encoding: chunked\r\n\r\n7\r\nabcdefg\r\n2\r\nhi\r\n0\r\n\r\n", buf.String())
+
+	// Test: Declared Content-Length is sent without chunking
+	buf.Reset()
+	w = NewWriterSize(buf, 2)
+	w.Header().Set("Content-Length", "5")
+	_, err = w.Write([]byte("hel"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("lo"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello", buf.String())
+
+	// Test: Handler that writes nothing
+	buf.Reset()
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n", buf.String())
+
+	// Test: Repeated WriteBody calls after explicit headers
+	buf.Reset()
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+	_, err = w.WriteBody([]byte("ab"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("cd"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nabcd")))
+
+	// Test: Writing after Finish fails
+	_, err = w.Write([]byte("late"))
+	require.Error(t, err)
+}
+
+func TestWriterContentLength(t *testing.T) {
+	// Test: Writing past the declared length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hell"))
+	require.NoError(t, err)
+	n, err := w.WriteBody([]byte("o!"))
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 0, n)
+	_, err = w.Write([]byte("o"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Ending short of the declared length
+	buf.Reset()
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(100)))
+	_, err = w.WriteBody([]byte("only fifty-ish bytes"))
+	require.NoError(t, err)
+	require.Error(t, w.Finish())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Buffered body longer than the length set in Header
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Content-Length", "3")
+	_, err = w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.Finish(), ErrContentLength)
+	assert.True(t, w.ShouldClose())
+	assert.NotContains(t, buf.String(), "hello world")
+
+	// Test: Buffered body shorter than the length set in Header
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Content-Length", "100")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Error(t, w.Finish())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Connection: close from the handler
+	buf.Reset()
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Set("Connection", "close")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Invalid declared length
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = GetDefaultHeaders(0)
+	h.Replace("Content-Length", "-3")
+	require.Error(t, w.WriteHeaders(h))
+}
+
+func TestWriterHead(t *testing.T) {
+	// Test: Explicit headers keep Content-Length but drop the body
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetMethod("HEAD")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 5\r\n")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")))
+	assert.NotContains(t, buf.String(), "hello")
+
+	// Test: Buffered body still computes Content-Length
+	buf.Reset()
+	w = NewWriter(buf)
+	w.SetMethod("HEAD")
+	_, err = w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 11\r\n\r\n", buf.String())
+
+	// Test: Chunked bodies and trailers are dropped entirely
+	buf.Reset()
+	w = NewWriterSize(buf, 1)
+	w.SetMethod("HEAD")
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n", buf.String())
+}
+
+func TestWriteInformational(t *testing.T) {
+	// Test: Early hints before the final response
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetVersion("1.1")
+	require.NoError(t, w.WriteInformational(StatusProcessing, nil))
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 102 Processing\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\ncontent-length: 2\r\n\r\nok", buf.String())
+
+	// Test: Non informational codes are rejected
+	w = NewWriter(buf)
+	require.Error(t, w.WriteInformational(StatusOK, nil))
+	require.Error(t, w.WriteInformational(StatusSwitchingProtocols, nil))
+
+	// Test: Interim responses after the final status line are rejected
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteInformational(StatusContinue, nil))
+
+	// Test: HTTP/1.0 clients get no interim responses and no chunking
+	buf.Reset()
+	w = NewWriterSize(buf, 1)
+	w.SetVersion("1.0")
+	require.Error(t, w.WriteInformational(StatusEarlyHints, hints))
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\nstreamed", buf.String())
+	assert.True(t, w.ShouldClose())
+}
+
+func TestWriterDefaultHeaders(t *testing.T) {
+	defaults := headers.NewHeaders()
+	defaults.Set("Server", "httpfromtcp")
+	defaults.Set("Content-Type", "text/plain")
+
+	// Test: Defaults fill in missing fields without overriding the handler
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetDefaultHeaders(defaults)
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "server: httpfromtcp\r\n")
+	assert.Contains(t, buf.String(), "content-type: application/json\r\n")
+	assert.NotContains(t, buf.String(), "text/plain")
+
+	// Test: Interim responses do not carry defaults
+	buf.Reset()
+	w = NewWriter(buf)
+	w.SetDefaultHeaders(defaults)
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", buf.String())
+}
+
+func TestWriterHijack(t *testing.T) {
+	// Test: Writers without a hijacker
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	require.ErrorIs(t, err, ErrNotHijackable)
+
+	// Test: Hijacking after the response was started
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHijacker(func() (net.Conn, *bufio.ReadWriter, error) {
+		return nil, bufio.NewReadWriter(nil, bufio.NewWriter(buf)), nil
+	})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	_, _, err = w.Hijack()
+	require.Error(t, err)
+
+	// Test: The writer is unusable after a hijack
+	buf.Reset()
+	w = NewWriter(buf)
+	w.SetHijacker(func() (net.Conn, *bufio.ReadWriter, error) {
+		return nil, bufio.NewReadWriter(nil, bufio.NewWriter(buf)), nil
+	})
+	w.Header().Set("X-Test", "dropped")
+	_, rw, err := w.Hijack()
+	require.NoError(t, err)
+	require.NotNil(t, rw)
+	_, err = w.Write([]byte("body"))
+	require.ErrorIs(t, err, ErrHijacked)
+	require.NoError(t, w.Finish())
+	assert.Empty(t, buf.String())
+	_, _, err = w.Hijack()
+	require.ErrorIs(t, err, ErrHijacked)
+}
+
+func TestObserver(t *testing.T) {
+	// Test: Nothing sent yet
+	w := NewWriter(&bytes.Buffer{})
+	o := Observe(w)
+	assert.Equal(t, StatusCode(0), o.Status())
+	assert.Nil(t, o.Header())
+
+	// Test: Body larger than the buffer is sent chunked
+	w.Header().Set("X-Test", "yes")
+	_, err := w.Write(bytes.Repeat([]byte("a"), DefaultBufferSize+1))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("bc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, StatusOK, o.Status())
+	assert.Equal(t, "yes", o.Header()["x-test"])
+	assert.Equal(t, "chunked", o.Header()["transfer-encoding"])
+	assert.Equal(t, int64(DefaultBufferSize+3), o.BytesWritten())
+
+	// Test: Finish hooks run once
+	finished := 0
+	w = NewWriter(&bytes.Buffer{})
+	w.OnFinish(func() { finished++ })
+	require.NoError(t, w.Finish())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, 1, finished)
+}
@@ -0,0 +1,199 @@
+package responsetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+)
+
+const crlf = "\r\n"
+
+// Recorder captures everything a handler writes through its Writer in
+// memory so the response can be inspected without a network connection.
+type Recorder struct {
+	Writer *response.Writer
+	buf    bytes.Buffer
+	method string
+}
+
+// Result is a parsed response captured by a Recorder.
+type Result struct {
+	// Informational holds any interim 1xx responses sent before the final
+	// response, in order.
+	Informational []Interim
+	StatusCode    response.StatusCode
+	Reason        string
+	Headers       headers.Headers
+	Body          []byte
+	// Chunks holds the individual chunks of a chunked body, without the
+	// terminating zero length chunk. It is nil for other bodies.
+	Chunks   [][]byte
+	Trailers headers.Headers
+}
+
+// Interim is an informational response captured by a Recorder.
+type Interim struct {
+	StatusCode response.StatusCode
+	Headers    headers.Headers
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{}
+	r.Writer = response.NewWriter(&r.buf)
+	return r
+}
+
+// NewRecorderFor returns a Recorder whose Writer is set up to answer req,
+// the way the server sets up a connection's writer.
+func NewRecorderFor(req *request.Request) *Recorder {
+	r := NewRecorder()
+	r.method = req.RequestLine.Method
+	r.Writer.SetMethod(r.method)
+	r.Writer.SetVersion(req.RequestLine.HttpVersion)
+	return r
+}
+
+// Bytes returns the raw bytes written so far.
+func (r *Recorder) Bytes() []byte {
+	return r.buf.Bytes()
+}
+
+// Result finishes the response the way the server does when a handler
+// returns, then parses the recorded bytes as an HTTP response. Finishing
+// changes the Writer's state: once Result has been called the response is
+// complete and further writes through Writer fail.
+func (r *Recorder) Result() (*Result, error) {
+	if err := r.Writer.Finish(); err != nil {
+		return nil, err
+	}
+	data := r.buf.Bytes()
+	res := &Result{
+		Trailers: headers.NewHeaders(),
+	}
+	for {
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return nil, errors.New("no status line was written")
+		}
+		res.Headers = headers.NewHeaders()
+		if err := parseStatusLine(string(data[:idx]), res); err != nil {
+			return nil, err
+		}
+		data = data[idx+2:]
+
+		n, err := parseHeaders(data, res.Headers)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if res.StatusCode >= 200 {
+			break
+		}
+		res.Informational = append(res.Informational, Interim{
+			StatusCode: res.StatusCode,
+			Headers:    res.Headers,
+		})
+	}
+
+	if r.method == "HEAD" {
+		if len(data) != 0 {
+			return nil, fmt.Errorf("%d body bytes were written in response to HEAD", len(data))
+		}
+		return res, nil
+	}
+	if encoding, ok := res.Headers.Get("Transfer-Encoding"); ok && strings.EqualFold(encoding, "chunked") {
+		return res, parseChunked(data, res)
+	}
+	if lengthStr, ok := res.Headers.Get("Content-Length"); ok {
+		length, err := strconv.Atoi(lengthStr)
+		if err != nil {
+			return nil, fmt.Errorf("malformed Content-Length: %s", err)
+		}
+		if length != len(data) {
+			return nil, fmt.Errorf("Content-Length is %d but %d body bytes were written", length, len(data))
+		}
+	}
+	res.Body = data
+	return res, nil
+}
+
+func parseStatusLine(line string, res *Result) error {
+	version, rest, found := strings.Cut(line, " ")
+	if !found || version != "HTTP/1.1" {
+		return fmt.Errorf("malformed status line %q", line)
+	}
+	code, reason, found := strings.Cut(rest, " ")
+	if !found {
+		return fmt.Errorf("malformed status line %q", line)
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil {
+		return fmt.Errorf("malformed status code %q", code)
+	}
+	res.StatusCode = response.StatusCode(statusCode)
+	res.Reason = reason
+	return nil
+}
+
+func parseHeaders(data []byte, h headers.Headers) (int, error) {
+	total := 0
+	for {
+		n, done, err := h.Parse(data[total:])
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, errors.New("missing end of headers")
+		}
+		total += n
+		if done {
+			return total, nil
+		}
+	}
+}
+
+func parseChunked(data []byte, res *Result) error {
+	res.Body = []byte{}
+	res.Chunks = [][]byte{}
+	for {
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return errors.New("missing chunk size")
+		}
+		size, err := strconv.ParseInt(string(data[:idx]), 16, 64)
+		if err != nil {
+			return fmt.Errorf("malformed chunk size: %s", err)
+		}
+		data = data[idx+2:]
+		if size == 0 {
+			break
+		}
+		if int64(len(data)) < size+2 || string(data[size:size+2]) != crlf {
+			return errors.New("truncated chunk")
+		}
+		res.Chunks = append(res.Chunks, data[:size])
+		res.Body = append(res.Body, data[:size]...)
+		data = data[size+2:]
+	}
+	_, err := parseHeaders(data, res.Trailers)
+	return err
+}
+
+// NewRequest parses raw as an HTTP request, for handing to a handler under
+// test. Bare "\n" line endings are accepted for convenience.
+func NewRequest(raw string) (*request.Request, error) {
+	if !strings.Contains(raw, crlf) {
+		raw = strings.ReplaceAll(raw, "\n", crlf)
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	return req, nil
+}
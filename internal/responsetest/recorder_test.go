@@ -0,0 +1,89 @@
+package responsetest
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	// Test: Fixed length body
+	rec := NewRecorder()
+	require.NoError(t, rec.Writer.WriteStatusLine(response.StatusNotFound))
+	h := response.GetDefaultHeaders(4)
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, rec.Writer.WriteHeaders(h))
+	_, err := rec.Writer.WriteBody([]byte("gone"))
+	require.NoError(t, err)
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "Not Found", res.Reason)
+	assert.Equal(t, "text/plain", res.Headers["content-type"])
+	assert.Equal(t, "gone", string(res.Body))
+	assert.Nil(t, res.Chunks)
+
+	// Test: Chunked body with trailers
+	rec = NewRecorder()
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, rec.Writer.WriteStatusLine(response.StatusOK))
+	require.NoError(t, rec.Writer.WriteHeaders(h))
+	_, err = rec.Writer.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = rec.Writer.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = rec.Writer.WriteChunkedBodyDone(true)
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "11")
+	require.NoError(t, rec.Writer.WriteTrailers(trailers))
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("hello "), []byte("world")}, res.Chunks)
+	assert.Equal(t, "hello world", string(res.Body))
+	assert.Equal(t, "11", res.Trailers["x-content-length"])
+
+	// Test: Interim responses are captured separately
+	rec = NewRecorder()
+	require.NoError(t, rec.Writer.WriteInformational(response.StatusContinue, nil))
+	_, err = rec.Writer.Write([]byte("final"))
+	require.NoError(t, err)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	require.Len(t, res.Informational, 1)
+	assert.Equal(t, response.StatusContinue, res.Informational[0].StatusCode)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "final", string(res.Body))
+
+	// Test: Writes through io.Writer are framed when the result is taken
+	rec = NewRecorder()
+	_, err = rec.Writer.Write([]byte("implicit"))
+	require.NoError(t, err)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "8", res.Headers["content-length"])
+	assert.Equal(t, "implicit", string(res.Body))
+
+	// Test: Result finishes the response
+	_, err = rec.Writer.Write([]byte("more"))
+	require.Error(t, err)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, "implicit", string(res.Body))
+}
+
+func TestNewRequest(t *testing.T) {
+	req, err := NewRequest("POST /submit HTTP/1.1\nHost: localhost\nContent-Length: 2\n\nhi")
+	require.NoError(t, err)
+	assert.Equal(t, "POST", req.RequestLine.Method)
+	assert.Equal(t, "/submit", req.RequestLine.RequestTarget)
+	assert.Equal(t, "hi", string(req.Body))
+	assert.NotEmpty(t, req.RemoteAddr)
+
+	_, err = NewRequest("nonsense\r\n\r\n")
+	require.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"slices"
+	"strings"
+)
+
+// Group registers routes on a router under a shared path prefix and
+// middleware. Groups nest, with each inheriting the prefix and middleware
+// of its parent.
+type Group struct {
+	router     *Router
+	prefix     string
+	middleware []server.Middleware
+}
+
+// Group returns a group of routes below prefix, wrapped in mw inside any
+// middleware added with Use.
+func (r *Router) Group(prefix string, mw ...server.Middleware) *Group {
+	return &Group{router: r, prefix: strings.TrimSuffix(prefix, "/"), middleware: mw}
+}
+
+// Group returns a group nested in g, below g's prefix and inside its
+// middleware.
+func (g *Group) Group(prefix string, mw ...server.Middleware) *Group {
+	return &Group{
+		router:     g.router,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: append(slices.Clone(g.middleware), mw...),
+	}
+}
+
+// Use adds middleware to the routes and nested groups added to g from now
+// on.
+func (g *Group) Use(mw ...server.Middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+// Handle registers h for method at pattern below the group's prefix, wrapped
+// in the group's middleware and then the route's own mw.
+func (g *Group) Handle(method, pattern string, h server.Handler, mw ...server.Middleware) {
+	g.router.Handle(method, g.prefix+pattern, h, g.chain(mw)...)
+}
+
+// Mount mounts h at prefix below the group's prefix. See Router.Mount.
+func (g *Group) Mount(prefix string, h server.Handler, mw ...server.Middleware) {
+	g.router.Mount(g.prefix+prefix, h, g.chain(mw)...)
+}
+
+func (g *Group) Get(pattern string, h server.Handler, mw ...server.Middleware) {
+	g.Handle("GET", pattern, h, mw...)
+}
+
+func (g *Group) Post(pattern string, h server.Handler, mw ...server.Middleware) {
+	g.Handle("POST", pattern, h, mw...)
+}
+
+func (g *Group) Put(pattern string, h server.Handler, mw ...server.Middleware) {
+	g.Handle("PUT", pattern, h, mw...)
+}
+
+func (g *Group) Delete(pattern string, h server.Handler, mw ...server.Middleware) {
+	g.Handle("DELETE", pattern, h, mw...)
+}
+
+// chain returns the group's middleware followed by a route's own.
+func (g *Group) chain(mw []server.Middleware) []server.Middleware {
+	return append(slices.Clone(g.middleware), mw...)
+}
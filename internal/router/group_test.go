@@ -0,0 +1,89 @@
+package router
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// where answers with the mount path and target the handler was given.
+func where(w *response.Writer, req *request.Request) {
+	w.Write([]byte(req.MountPath + " " + req.RequestLine.RequestTarget))
+	if id, ok := req.Params["id"]; ok {
+		w.Write([]byte(" id=" + id))
+	}
+}
+
+func TestRouterMount(t *testing.T) {
+	api := New()
+	api.Get("/users/{id}", where)
+	r := New()
+	r.Mount("/api/", api.ServeHTTP)
+	r.Mount("/tenants/{id}/files", where)
+	r.Get("/api/status", echo("status"))
+	r.Get("/tenants/{id}/files/readme", echo("readme"))
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{"GET", "/api/users/7?expand=1", "/api /users/7?expand=1 id=7"},
+		{"GET", "/tenants/acme/files/a%20b/c.txt", "/tenants/acme/files /a%20b/c.txt id=acme"},
+		{"POST", "/tenants/acme/files", "/tenants/acme/files / id=acme"},
+		{"GET", "/api/status", "status"},
+		{"GET", "/tenants/acme/files/readme", "readme id=acme"},
+		// routes below a mount only hide it for their own methods
+		{"POST", "/tenants/acme/files/readme", "/tenants/acme/files /readme id=acme"},
+	}
+	for _, tt := range tests {
+		res := serve(t, r, tt.method, tt.target)
+		assert.Equal(t, response.StatusOK, res.StatusCode, tt.target)
+		assert.Equal(t, tt.body, string(res.Body), tt.target)
+	}
+
+	// Test: The mounted router answers for its own routes
+	res := serve(t, r, "GET", "/api/nope")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+	res = serve(t, r, "DELETE", "/api/users/7")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers["allow"])
+
+	// Test: Other methods on a route below a mount reach the mounted router
+	res = serve(t, r, "POST", "/api/status")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+	assert.Empty(t, res.Headers["allow"])
+
+	// Test: Paths beside the prefix do not match
+	res = serve(t, r, "GET", "/apiary")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+
+	// Test: Mounts cannot end in a wildcard
+	assert.Panics(t, func() { r.Mount("/static/{path...}", where) })
+}
+
+func TestRouterGroup(t *testing.T) {
+	r := New()
+	api := r.Group("/api", header("api"))
+	api.Get("/ping", echo("ping"))
+	admin := api.Group("/admin/", header("admin"))
+	admin.Use(header("audited"))
+	admin.Delete("/users/{id}", echo("delete user"), header("route"))
+	admin.Mount("/files", where)
+
+	// Test: Routes inherit the prefixes and middleware of their groups
+	res := serve(t, r, "GET", "/api/ping")
+	assert.Equal(t, "ping", string(res.Body))
+	assert.Equal(t, "api", res.Headers["x-chain"])
+	res = serve(t, r, "DELETE", "/api/admin/users/3")
+	assert.Equal(t, "delete user id=3", string(res.Body))
+	assert.Equal(t, "api, admin, audited, route", res.Headers["x-chain"])
+	res = serve(t, r, "GET", "/api/admin/files/report.pdf")
+	assert.Equal(t, "/api/admin/files /report.pdf", string(res.Body))
+	assert.Equal(t, "api, admin, audited", res.Headers["x-chain"])
+
+	// Test: Middleware added to a nested group stays there
+	res = serve(t, r, "GET", "/api/ping")
+	assert.Equal(t, "api", res.Headers["x-chain"])
+}
@@ -0,0 +1,469 @@
+// Package router dispatches requests to handlers by method and path
+// pattern.
+//
+// A pattern is a path made of segments separated by slashes. Each segment is
+// either literal text, a parameter such as {id} that matches any single
+// segment, or, as the last segment only, a wildcard such as {path...} that
+// matches the rest of the path, including further slashes. The values
+// matched by parameters and wildcards are stored in the request's Params.
+//
+// When several patterns match a path, literal segments take precedence over
+// parameters, and parameters over wildcards, segment by segment from the
+// left. A route that does not accept the request's method gives way to the
+// next matching route that does, and a request is only answered with 405
+// Method Not Allowed when none of them do.
+//
+// Whole handlers, including other routers, can be mounted below a prefix
+// with Mount, and routes sharing a prefix and middleware can be registered
+// together through a Group.
+package router
+
+import (
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Router is a table of routes. Its ServeHTTP method is a server.Handler.
+// Routes must all be added before the router starts serving.
+type Router struct {
+	// NotFound handles requests whose path matches no route. Nil means a
+	// 404 rendered with ErrorRenderer.
+	NotFound server.Handler
+	// ErrorRenderer renders the 404 and 405 responses the router sends on
+	// its own. Nil means server.DefaultErrorRenderer.
+	ErrorRenderer server.ErrorRenderer
+
+	root       *node
+	middleware []server.Middleware
+}
+
+// node is a segment of the route tree.
+type node struct {
+	literals map[string]*node
+	// param matches any single segment and stores it under paramName
+	param     *node
+	paramName string
+	// wildcardName is set when a wildcard route ends at this node, matching
+	// whatever is left of the path
+	wildcardName     string
+	wildcardHandlers map[string]server.Handler
+	handlers         map[string]server.Handler
+}
+
+func New() *Router {
+	return &Router{root: &node{}}
+}
+
+// Use adds middleware that wraps every request the router serves, including
+// those answered with 404 or 405. Middleware added first is outermost.
+func (r *Router) Use(mw ...server.Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle registers h for requests with method whose path matches pattern,
+// wrapped in the route's own middleware mw inside any added with Use. It
+// panics if the pattern is malformed, conflicts with the parameter names of
+// another route, or is already registered for method.
+func (r *Router) Handle(method, pattern string, h server.Handler, mw ...server.Middleware) {
+	if method == "" || h == nil {
+		panic(fmt.Sprintf("router: invalid route %s %q", method, pattern))
+	}
+	segments, err := splitPattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: %v", err))
+	}
+	r.add(method, pattern, segments, server.Chain(h, mw...))
+}
+
+// Mount routes every request whose path is prefix or lies below it to h,
+// whatever its method, wrapped in mw. h sees the request target with the
+// prefix stripped, so a request for /api/users?page=2 reaches a handler
+// mounted at /api as /users?page=2, and the stripped prefix is added to the
+// request's MountPath. The prefix may contain parameters. Routes registered
+// below the prefix take precedence over the mount for the methods they
+// accept; requests with other methods still reach the mount.
+func (r *Router) Mount(prefix string, h server.Handler, mw ...server.Middleware) {
+	if h == nil {
+		panic(fmt.Sprintf("router: invalid mount %q", prefix))
+	}
+	var segments []string
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		var err error
+		segments, err = splitPattern(prefix)
+		if err != nil {
+			panic(fmt.Sprintf("router: %v", err))
+		}
+	}
+	if len(segments) > 0 {
+		if _, kind := parseSegment(segments[len(segments)-1]); kind == segmentWildcard {
+			panic(fmt.Sprintf("router: mount prefix %q cannot end in a wildcard", prefix))
+		}
+	}
+	segments = append(segments, "{"+mountParam+"...}")
+	r.add(anyMethod, prefix+"/", segments, server.Chain(h, mw...))
+}
+
+// add registers h for method at the node for segments.
+func (r *Router) add(method, pattern string, segments []string, h server.Handler) {
+	n := r.root
+	for i, seg := range segments {
+		name, kind := parseSegment(seg)
+		switch kind {
+		case segmentLiteral:
+			if n.literals == nil {
+				n.literals = map[string]*node{}
+			}
+			if n.literals[seg] == nil {
+				n.literals[seg] = &node{}
+			}
+			n = n.literals[seg]
+		case segmentParam:
+			if n.param == nil {
+				n.param = &node{}
+				n.paramName = name
+			} else if n.paramName != name {
+				panic(fmt.Sprintf("router: parameter {%s} in %q conflicts with {%s}", name, pattern, n.paramName))
+			}
+			n = n.param
+		case segmentWildcard:
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("router: wildcard {%s...} must end pattern %q", name, pattern))
+			}
+			if n.wildcardName != "" && n.wildcardName != name {
+				panic(fmt.Sprintf("router: wildcard {%s...} in %q conflicts with {%s...}", name, pattern, n.wildcardName))
+			}
+			n.wildcardName = name
+			n.wildcardHandlers = addHandler(n.wildcardHandlers, method, pattern, h)
+			return
+		}
+	}
+	n.handlers = addHandler(n.handlers, method, pattern, h)
+}
+
+func addHandler(handlers map[string]server.Handler, method, pattern string, h server.Handler) map[string]server.Handler {
+	if handlers == nil {
+		handlers = map[string]server.Handler{}
+	}
+	if _, ok := handlers[method]; ok {
+		panic(fmt.Sprintf("router: %s %q is already registered", method, pattern))
+	}
+	handlers[method] = h
+	return handlers
+}
+
+const (
+	// anyMethod registers a handler for every method
+	anyMethod = "*"
+	// mountParam names the wildcard holding the part of the path below a
+	// mount point. It is not a valid parameter name, so it cannot clash
+	// with the routes' own.
+	mountParam = "*"
+)
+
+// Get registers h for GET requests matching pattern. GET routes also answer
+// HEAD requests unless a HEAD route is registered for the same pattern.
+func (r *Router) Get(pattern string, h server.Handler, mw ...server.Middleware) {
+	r.Handle("GET", pattern, h, mw...)
+}
+
+func (r *Router) Post(pattern string, h server.Handler, mw ...server.Middleware) {
+	r.Handle("POST", pattern, h, mw...)
+}
+
+func (r *Router) Put(pattern string, h server.Handler, mw ...server.Middleware) {
+	r.Handle("PUT", pattern, h, mw...)
+}
+
+func (r *Router) Delete(pattern string, h server.Handler, mw ...server.Middleware) {
+	r.Handle("DELETE", pattern, h, mw...)
+}
+
+// ServeHTTP dispatches req to the handler of the route matching its method
+// and path. A path that matches no route is answered by NotFound, and one
+// that only matches routes for other methods with 405 Method Not Allowed and
+// an Allow header listing the methods those routes do support. OPTIONS requests
+// without a route of their own are answered with that Allow header, and
+// OPTIONS * with every method any route supports.
+func (r *Router) ServeHTTP(w *response.Writer, req *request.Request) {
+	server.Chain(r.dispatch, r.middleware...)(w, req)
+}
+
+func (r *Router) dispatch(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "OPTIONS" && req.RequestLine.RequestTarget == "*" {
+		allow(w, allowedMethods(r.root.methods(map[string]bool{})))
+		return
+	}
+	h, params, allowed := r.route(req.RequestLine.Method, requestPath(req.RequestLine.RequestTarget))
+	if h == nil {
+		switch {
+		case len(allowed) == 0:
+			r.notFound(w, req)
+		case req.RequestLine.Method == "OPTIONS":
+			allow(w, allowedMethods(allowed))
+		default:
+			allow := headers.NewHeaders()
+			allow.Set("Allow", strings.Join(allowedMethods(allowed), ", "))
+			r.sendError(w, req, server.HandlerError{StatusCode: response.StatusMethodNotAllowed, Headers: allow})
+		}
+		return
+	}
+	if rest, ok := params[mountParam]; ok {
+		delete(params, mountParam)
+		req = mountedRequest(req, rest)
+	}
+	if len(params) > 0 {
+		if req.Params == nil {
+			req.Params = map[string]string{}
+		}
+		for key, val := range params {
+			req.Params[key] = val
+		}
+	}
+	h(w, req)
+}
+
+// mountedRequest returns a copy of req for a handler mounted at the part of
+// its path before rest, with that prefix moved from the request target to
+// MountPath. The original is left alone for middleware outside the mount.
+func mountedRequest(req *request.Request, rest string) *request.Request {
+	path, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+	prefix := strings.TrimSuffix(strings.TrimSuffix(path, rest), "/")
+	mounted := *req
+	mounted.MountPath = req.MountPath + prefix
+	mounted.RequestLine.RequestTarget = "/" + rest
+	if hasQuery {
+		mounted.RequestLine.RequestTarget += "?" + query
+	}
+	mounted.Params = maps.Clone(req.Params)
+	return &mounted
+}
+
+func (r *Router) notFound(w *response.Writer, req *request.Request) {
+	if r.NotFound != nil {
+		r.NotFound(w, req)
+		return
+	}
+	r.sendError(w, req, server.HandlerError{StatusCode: response.StatusNotFound})
+}
+
+func (r *Router) sendError(w *response.Writer, req *request.Request, herr server.HandlerError) {
+	server.HandleErrors(func(*response.Writer, *request.Request) error {
+		return herr
+	}, r.ErrorRenderer)(w, req)
+}
+
+// allow answers an OPTIONS request with the allowed methods.
+func allow(w *response.Writer, methods []string) {
+	w.Header().Replace("Allow", strings.Join(methods, ", "))
+	w.WriteStatusLine(response.StatusOK)
+}
+
+// allowedMethods lists the methods of routes in sorted order, including
+// HEAD when GET is supported and OPTIONS, which the router answers itself.
+func allowedMethods[V any](handlers map[string]V) []string {
+	methods := make([]string, 0, len(handlers)+2)
+	for method := range handlers {
+		if method != anyMethod {
+			methods = append(methods, method)
+		}
+	}
+	for _, implied := range []string{"HEAD", "OPTIONS"} {
+		if _, ok := handlers[implied]; ok {
+			continue
+		}
+		if _, ok := handlers["GET"]; ok || implied == "OPTIONS" {
+			methods = append(methods, implied)
+		}
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// methods adds the methods of every route at or below n to seen, for
+// answering OPTIONS *.
+func (n *node) methods(seen map[string]bool) map[string]bool {
+	for _, handlers := range []map[string]server.Handler{n.handlers, n.wildcardHandlers} {
+		for method := range handlers {
+			seen[method] = true
+		}
+	}
+	for _, child := range n.literals {
+		child.methods(seen)
+	}
+	if n.param != nil {
+		n.param.methods(seen)
+	}
+	return seen
+}
+
+// route finds the handler for method of the best matching route for path
+// that accepts method, along with the parameters that route extracted. If no
+// matching route accepts method, it returns a nil handler and the methods
+// the matching routes do accept, which are empty if no route matches path.
+func (r *Router) route(method, path string) (server.Handler, map[string]string, map[string]bool) {
+	allowed := map[string]bool{}
+	if !strings.HasPrefix(path, "/") {
+		return nil, nil, allowed
+	}
+	var params []string
+	var h server.Handler
+	r.root.match(strings.Split(path[1:], "/"), &params, func(handlers map[string]server.Handler) bool {
+		if found, ok := methodHandler(handlers, method); ok {
+			h = found
+			return true
+		}
+		for m := range handlers {
+			allowed[m] = true
+		}
+		return false
+	})
+	if h == nil {
+		return nil, nil, allowed
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		// the path below a mount point stays encoded, as it becomes the
+		// mounted handler's request target
+		if params[i] == mountParam {
+			values[params[i]] = params[i+1]
+		} else {
+			values[params[i]] = unescape(params[i+1])
+		}
+	}
+	return h, values, nil
+}
+
+// methodHandler picks the handler for method from a route's handlers,
+// falling back to GET for HEAD and to a handler for any method.
+func methodHandler(handlers map[string]server.Handler, method string) (server.Handler, bool) {
+	h, ok := handlers[method]
+	if !ok && method == "HEAD" {
+		h, ok = handlers["GET"]
+	}
+	if !ok {
+		h, ok = handlers[anyMethod]
+	}
+	return h, ok
+}
+
+// match walks the tree for segments, backtracking from literal to parameter
+// to wildcard routes, and calls visit with the handlers of each route that
+// matches in that order until visit returns true. params collects name and
+// value pairs of the route being tried.
+func (n *node) match(segments []string, params *[]string, visit func(map[string]server.Handler) bool) bool {
+	if len(segments) == 0 {
+		if n.handlers != nil && visit(n.handlers) {
+			return true
+		}
+		// a wildcard also matches an empty remainder
+		return n.matchWildcard("", params, visit)
+	}
+	seg, rest := segments[0], segments[1:]
+	if child, ok := n.literals[seg]; ok {
+		if child.match(rest, params, visit) {
+			return true
+		}
+	}
+	if n.param != nil && seg != "" {
+		mark := len(*params)
+		*params = append(*params, n.paramName, seg)
+		if n.param.match(rest, params, visit) {
+			return true
+		}
+		*params = (*params)[:mark]
+	}
+	return n.matchWildcard(strings.Join(segments, "/"), params, visit)
+}
+
+func (n *node) matchWildcard(rest string, params *[]string, visit func(map[string]server.Handler) bool) bool {
+	if n.wildcardHandlers == nil {
+		return false
+	}
+	mark := len(*params)
+	*params = append(*params, n.wildcardName, rest)
+	if visit(n.wildcardHandlers) {
+		return true
+	}
+	*params = (*params)[:mark]
+	return false
+}
+
+// requestPath returns the path of an origin-form request target, without
+// its query.
+func requestPath(target string) string {
+	path, _, _ := strings.Cut(target, "?")
+	return path
+}
+
+// unescape decodes percent-encoding in a matched value, leaving values that
+// are not validly encoded as they are.
+func unescape(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+// parseSegment classifies a pattern segment, returning the parameter name
+// of a parameter or wildcard.
+func parseSegment(seg string) (string, segmentKind) {
+	if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+		return "", segmentLiteral
+	}
+	name := seg[1 : len(seg)-1]
+	if wildcard, ok := strings.CutSuffix(name, "..."); ok {
+		return wildcard, segmentWildcard
+	}
+	return name, segmentParam
+}
+
+// splitPattern splits a pattern into its segments, checking that it is an
+// absolute path with well-formed parameters.
+func splitPattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q does not start with /", pattern)
+	}
+	segments := strings.Split(pattern[1:], "/")
+	for _, seg := range segments {
+		name, kind := parseSegment(seg)
+		if kind == segmentLiteral {
+			if strings.ContainsAny(seg, "{}") {
+				return nil, fmt.Errorf("pattern %q has a malformed parameter %q", pattern, seg)
+			}
+			continue
+		}
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("pattern %q has an invalid parameter name %q", pattern, name)
+		}
+	}
+	return segments, nil
+}
+
+// isIdentifier reports whether name is made of letters, digits and
+// underscores.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
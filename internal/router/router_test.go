@@ -0,0 +1,187 @@
+package router
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/responsetest"
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// echo answers with the route name and the request's params.
+func echo(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(name))
+		for _, key := range []string{"id", "file", "path"} {
+			if val, ok := req.Params[key]; ok {
+				w.Write([]byte(" " + key + "=" + val))
+			}
+		}
+	}
+}
+
+func serve(t *testing.T, r *Router, method, target string) *responsetest.Result {
+	t.Helper()
+	req, err := responsetest.NewRequest(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	r.ServeHTTP(rec.Writer, req)
+	res, err := rec.Result()
+	require.NoError(t, err)
+	return res
+}
+
+func TestRouter(t *testing.T) {
+	r := New()
+	r.Get("/", echo("index"))
+	r.Get("/users/{id}", echo("user"))
+	r.Delete("/users/{id}", echo("delete user"))
+	r.Get("/users/me", echo("me"))
+	r.Get("/users/{id}/files/{file}", echo("file"))
+	r.Get("/static/{path...}", echo("static"))
+	r.Post("/upload", echo("upload"))
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{"GET", "/", "index"},
+		{"GET", "/users/42", "user id=42"},
+		{"DELETE", "/users/42", "delete user id=42"},
+		{"GET", "/users/me", "me"},
+		{"GET", "/users/7/files/a%20b.txt?download=1", "file id=7 file=a b.txt"},
+		{"GET", "/static/css/site.css", "static path=css/site.css"},
+		{"GET", "/static/", "static path="},
+		{"POST", "/upload", "upload"},
+	}
+	for _, tt := range tests {
+		res := serve(t, r, tt.method, tt.target)
+		assert.Equal(t, response.StatusOK, res.StatusCode, tt.target)
+		assert.Equal(t, tt.body, string(res.Body), tt.target)
+	}
+
+	// Test: HEAD falls back to GET
+	res := serve(t, r, "HEAD", "/users/42")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "10", res.Headers["content-length"])
+
+	// Test: Unknown paths
+	for _, target := range []string{"/nope", "/users", "/users/42/files", "/users//files/x"} {
+		res = serve(t, r, "GET", target)
+		assert.Equal(t, response.StatusNotFound, res.StatusCode, target)
+	}
+
+	// Test: Known path with the wrong method
+	res = serve(t, r, "POST", "/users/42")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", res.Headers["allow"])
+	res = serve(t, r, "GET", "/upload")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "OPTIONS, POST", res.Headers["allow"])
+
+	// Test: A literal route without the method gives way to a parameter
+	r.Post("/users/new", echo("new user"))
+	res = serve(t, r, "GET", "/users/new")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "user id=new", string(res.Body))
+	res = serve(t, r, "POST", "/users/new")
+	assert.Equal(t, "new user", string(res.Body))
+
+	// Test: Allow lists the methods of every route matching the path
+	res = serve(t, r, "PUT", "/users/new")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, POST", res.Headers["allow"])
+	res = serve(t, r, "OPTIONS", "/users/new")
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, POST", res.Headers["allow"])
+
+	// Test: Custom not found handler
+	r.NotFound = echo("custom")
+	res = serve(t, r, "GET", "/nope")
+	assert.Equal(t, "custom", string(res.Body))
+}
+
+func TestRouterPatterns(t *testing.T) {
+	r := New()
+	r.Get("/items/{id}", echo("item"))
+
+	// Test: Invalid and conflicting patterns panic
+	for _, register := range []func(){
+		func() { r.Get("items", echo("relative")) },
+		func() { r.Get("/items/{id}", echo("duplicate")) },
+		func() { r.Get("/items/{name}", echo("renamed")) },
+		func() { r.Get("/files/{path...}/raw", echo("wildcard not last")) },
+		func() { r.Get("/bad/{id", echo("unterminated")) },
+		func() { r.Get("/bad/{a-b}", echo("bad name")) },
+	} {
+		assert.Panics(t, register)
+	}
+}
+
+// header is a middleware that adds a value to a response header.
+func header(val string) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.Header().Set("X-Chain", val)
+			next(w, req)
+		}
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	var seen []string
+	r := New()
+	r.Use(header("global"), func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			seen = append(seen, req.RequestLine.RequestTarget)
+			next(w, req)
+		}
+	})
+	r.Get("/plain", echo("plain"))
+	r.Get("/wrapped", echo("wrapped"), header("route one"), header("route two"))
+
+	// Test: Global middleware runs before route middleware
+	res := serve(t, r, "GET", "/wrapped")
+	assert.Equal(t, "global, route one, route two", res.Headers["x-chain"])
+	res = serve(t, r, "GET", "/plain")
+	assert.Equal(t, "global", res.Headers["x-chain"])
+
+	// Test: Global middleware also wraps 404 and 405 responses
+	res = serve(t, r, "GET", "/nope")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+	res = serve(t, r, "POST", "/plain")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, []string{"/wrapped", "/plain", "/nope", "/plain"}, seen)
+}
+
+func TestRouterOptions(t *testing.T) {
+	r := New()
+	r.Get("/video", echo("video"))
+	r.Post("/upload", echo("upload"))
+	r.Handle("OPTIONS", "/custom", echo("custom options"))
+	r.Mount("/api", echo("api"))
+
+	// Test: Allow derived from the route
+	res := serve(t, r, "OPTIONS", "/video")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers["allow"])
+	assert.Equal(t, "0", res.Headers["content-length"])
+	assert.Empty(t, res.Body)
+
+	// Test: Explicit OPTIONS routes and mounts answer for themselves
+	res = serve(t, r, "OPTIONS", "/custom")
+	assert.Equal(t, "custom options", string(res.Body))
+	res = serve(t, r, "OPTIONS", "/api/anything")
+	assert.Equal(t, "api", string(res.Body))
+
+	// Test: Unknown paths
+	res = serve(t, r, "OPTIONS", "/nope")
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+
+	// Test: Server-wide probe
+	res = serve(t, r, "OPTIONS", "*")
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", res.Headers["allow"])
+}
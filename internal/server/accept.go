@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// minAcceptDelay and maxAcceptDelay bound the pause after a temporary
+	// Accept error, which doubles with each consecutive failure.
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+	// acceptLogInterval is the least time between logged Accept errors.
+	acceptLogInterval = time.Second
+)
+
+// acceptBackoff tracks consecutive Accept failures for the Serve loop.
+type acceptBackoff struct {
+	failures   int
+	delay      time.Duration
+	lastLog    time.Time
+	suppressed int
+}
+
+// failed records a failed Accept, notifies the server's error callback and
+// logs the error unless one was logged recently. It reports whether the
+// error is temporary and Serve should try again, after waiting for the
+// backoff delay or until the server closes.
+func (b *acceptBackoff) failed(s *Server, err error) bool {
+	b.failures++
+	if s.OnAcceptError != nil {
+		s.OnAcceptError(err, b.failures)
+	}
+	temporary := isTemporary(err)
+	if now := time.Now(); !temporary || now.Sub(b.lastLog) >= acceptLogInterval {
+		if b.suppressed > 0 {
+			s.logf("Error accepting connection: %v (%d earlier errors not logged)", err, b.suppressed)
+		} else {
+			s.logf("Error accepting connection: %v", err)
+		}
+		b.lastLog = now
+		b.suppressed = 0
+	} else {
+		b.suppressed++
+	}
+	if !temporary {
+		return false
+	}
+
+	if b.delay == 0 {
+		b.delay = minAcceptDelay
+	} else {
+		b.delay = min(2*b.delay, maxAcceptDelay)
+	}
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.done:
+	}
+	return true
+}
+
+// succeeded resets the backoff after a successful Accept.
+func (b *acceptBackoff) succeeded() {
+	b.failures = 0
+	b.delay = 0
+}
+
+// isTemporary reports whether an Accept error may clear up on its own, such
+// as running out of file descriptors or a client aborting its handshake.
+func isTemporary(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) {
+		return temp.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
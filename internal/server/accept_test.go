@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingListener fails Accept with each of errs in turn.
+type failingListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	err := l.errs[0]
+	if len(l.errs) > 1 {
+		l.errs = l.errs[1:]
+	}
+	return nil, err
+}
+
+func (l *failingListener) Close() error { return nil }
+
+func TestAcceptBackoff(t *testing.T) {
+	emfile := fmt.Errorf("accept tcp: %w", syscall.EMFILE)
+	fatal := errors.New("listener broke")
+	logs := &strings.Builder{}
+	var failures []int
+	s := &Server{
+		Logger: log.New(logs, "", 0),
+		OnAcceptError: func(err error, n int) {
+			failures = append(failures, n)
+		},
+	}
+
+	// Test: Temporary errors are retried with backoff until a fatal one
+	start := time.Now()
+	err := s.Serve(&failingListener{errs: []error{emfile, emfile, emfile, fatal}})
+	require.ErrorIs(t, err, fatal)
+	assert.Equal(t, []int{1, 2, 3, 4}, failures)
+	assert.GreaterOrEqual(t, time.Since(start), (5+10+20)*time.Millisecond)
+
+	// Test: Repeated temporary errors are logged once per interval
+	assert.Equal(t, 1, strings.Count(logs.String(), "too many open files"), logs.String())
+	assert.Contains(t, logs.String(), "listener broke (2 earlier errors not logged)")
+
+	// Test: Close interrupts the backoff
+	s = &Server{Logger: log.New(logs, "", 0)}
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(&failingListener{errs: []error{emfile}})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, s.Close())
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ConnState is the state of a client connection, reported to the server's
+// ConnState hook on every transition.
+type ConnState int32
+
+const (
+	// StateNew is a connection that has just been accepted.
+	StateNew ConnState = iota
+	// StateReadingHeaders is a connection whose next request has started to
+	// arrive.
+	StateReadingHeaders
+	// StateActive is a connection with a request being handled.
+	StateActive
+	// StateIdle is a keep-alive connection waiting for its next request.
+	StateIdle
+	// StateHijacked is a connection a handler took over with Hijack. It is
+	// final; the server no longer tracks the connection.
+	StateHijacked
+	// StateClosed is a connection the server has closed. It is final.
+	StateClosed
+)
+
+var connStateNames = map[ConnState]string{
+	StateNew:            "new",
+	StateReadingHeaders: "reading headers",
+	StateActive:         "active",
+	StateIdle:           "idle",
+	StateHijacked:       "hijacked",
+	StateClosed:         "closed",
+}
+
+func (state ConnState) String() string {
+	if name, ok := connStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConnState(%d)", int(state))
+}
+
+// ConnInfo identifies a connection in ConnState hooks.
+type ConnInfo struct {
+	// ID numbers the server's connections in the order they were accepted.
+	ID         uint64
+	RemoteAddr string
+}
+
+// conn is a client connection and the loop serving its requests.
+type conn struct {
+	server *Server
+	info   ConnInfo
+	rwc    net.Conn
+	reader *request.Reader
+	bw     *bufio.Writer
+	state  atomic.Int32
+}
+
+func (s *Server) newConn(rwc net.Conn) *conn {
+	c := &conn{
+		server: s,
+		info: ConnInfo{
+			ID:         s.nextConnID.Add(1),
+			RemoteAddr: rwc.RemoteAddr().String(),
+		},
+		rwc:    rwc,
+		reader: request.NewReader(rwc),
+		bw:     bufio.NewWriter(rwc),
+	}
+	if s.MaxHeaderBytes > 0 {
+		c.reader.MaxHeaderBytes = s.MaxHeaderBytes
+	}
+	if s.MaxBodyBytes > 0 {
+		c.reader.MaxBodyBytes = s.MaxBodyBytes
+	}
+	c.reader.OnHeaders = func(req *request.Request) {
+		c.rwc.SetReadDeadline(deadline(s.ReadBodyTimeout))
+		// the whole body is read before the handler runs, so the client
+		// must be told to send it now
+		if req.ExpectsContinue() {
+			c.rwc.SetWriteDeadline(deadline(s.WriteTimeout))
+			if response.WriteStatusLine(c.bw, response.StatusContinue) == nil && response.WriteHeaders(c.bw, headers.NewHeaders()) == nil {
+				c.bw.Flush()
+			}
+		}
+	}
+	return c
+}
+
+// serve answers requests on the connection until either side wants it
+// closed or the server shuts down.
+func (c *conn) serve() {
+	s := c.server
+	defer c.close()
+	for first := true; ; first = false {
+		req, err := c.readRequest(first)
+		if err != nil {
+			s.rejectRequest(c.rwc, err)
+			return
+		}
+		c.rwc.SetReadDeadline(time.Time{})
+		c.rwc.SetWriteDeadline(deadline(s.WriteTimeout))
+		if !c.setState(StateActive) {
+			return
+		}
+		req.RemoteAddr = c.info.RemoteAddr
+
+		keepAlive := wantsKeepAlive(req) && !s.shuttingDown()
+		defaults := s.responseDefaults()
+		if !keepAlive {
+			defaults.Replace("Connection", "close")
+		} else if req.RequestLine.HttpVersion == "1.0" {
+			defaults.Replace("Connection", "keep-alive")
+		}
+
+		writer := response.NewWriter(c.bw)
+		writer.SetMethod(req.RequestLine.Method)
+		writer.SetVersion(req.RequestLine.HttpVersion)
+		writer.SetDefaultHeaders(defaults)
+		writer.SetHijacker(c.hijack)
+		writer.OnHeaders(func(_ response.StatusCode, h headers.Headers) {
+			// a shutdown that started while the handler ran ends the
+			// connection after this response
+			if s.shuttingDown() {
+				h.Replace("Connection", "close")
+			}
+		})
+		if !s.acquireHandler() {
+			// the server was closed, along with this connection
+			return
+		}
+		ok := s.runHandler(writer, req)
+		s.releaseHandler()
+		if c.hijacked() {
+			return
+		}
+		if !ok {
+			c.bw.Reset(c.rwc)
+			abort(c.rwc)
+			return
+		}
+		if err := writer.Finish(); err != nil {
+			s.logf("Error finishing response to %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			return
+		}
+		if !keepAlive || writer.ShouldClose() {
+			return
+		}
+		if !c.setState(StateIdle) || s.shuttingDown() {
+			return
+		}
+	}
+}
+
+// readRequest reads the next request under the read deadlines and checks
+// its Host header. Between requests the idle timeout applies until the next
+// one starts to arrive.
+func (c *conn) readRequest(first bool) (*request.Request, error) {
+	s := c.server
+	if first {
+		c.rwc.SetReadDeadline(deadline(s.ReadHeaderTimeout))
+	} else if !c.reader.Buffered() {
+		c.rwc.SetReadDeadline(deadline(s.idleTimeout()))
+	}
+	if err := c.reader.WaitForRequest(); err != nil {
+		return nil, err
+	}
+	// a shutdown may have closed the connection while it was idle
+	if !c.setState(StateReadingHeaders) {
+		return nil, net.ErrClosed
+	}
+	if !first {
+		c.rwc.SetReadDeadline(deadline(s.ReadHeaderTimeout))
+	}
+	req, err := c.reader.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	if err := req.ValidateHost(); err != nil {
+		return nil, err
+	}
+	req.UseOriginForm()
+	return req, nil
+}
+
+// setState moves the connection to state, reporting false if it was closed
+// or hijacked in the meantime.
+func (c *conn) setState(state ConnState) bool {
+	for {
+		current := c.state.Load()
+		if ConnState(current) == StateClosed || ConnState(current) == StateHijacked {
+			return false
+		}
+		if c.state.CompareAndSwap(current, int32(state)) {
+			c.server.trackConn(c, state)
+			c.server.connStateHook(c, state)
+			return true
+		}
+	}
+}
+
+// closeIfIdle closes the connection if it is not handling a request,
+// reporting whether it did.
+func (c *conn) closeIfIdle() bool {
+	for _, idle := range []ConnState{StateIdle, StateNew} {
+		if c.state.CompareAndSwap(int32(idle), int32(StateClosed)) {
+			c.rwc.Close()
+			return true
+		}
+	}
+	return false
+}
+
+func (c *conn) hijacked() bool {
+	return ConnState(c.state.Load()) == StateHijacked
+}
+
+// hijack hands the connection to a handler, together with any bytes of the
+// client's stream that were read past the request.
+func (c *conn) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// flush while the server still owns the connection, so it is closed
+	// as usual if that fails
+	if err := c.bw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if !c.setState(StateHijacked) {
+		return nil, nil, net.ErrClosed
+	}
+	c.server.releaseConnSlot()
+	c.rwc.SetDeadline(time.Time{})
+	src := io.MultiReader(bytes.NewReader(c.reader.TakeBuffered()), c.rwc)
+	return c.rwc, bufio.NewReadWriter(bufio.NewReader(src), c.bw), nil
+}
+
+// close closes the connection once the serve loop is done with it, unless a
+// handler has taken it over.
+func (c *conn) close() {
+	if c.hijacked() {
+		return
+	}
+	c.state.Store(int32(StateClosed))
+	c.rwc.Close()
+	c.server.trackConn(c, StateClosed)
+	c.server.releaseConnSlot()
+	c.server.connStateHook(c, StateClosed)
+}
+
+// wantsKeepAlive reports whether the client is willing to send another
+// request on the connection after this one.
+func wantsKeepAlive(req *request.Request) bool {
+	if req.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	if req.RequestLine.HttpVersion == "1.0" {
+		return req.Headers.HasToken("Connection", "keep-alive")
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnStateHook(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	var infos []ConnInfo
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			w.Write([]byte("ok"))
+		},
+		ConnState: func(info ConnInfo, state ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+			infos = append(infos, info)
+		},
+	}
+	addr := startServer(t, s)
+
+	// Test: Transitions over two keep-alive requests
+	conn := dial(t, addr)
+	r := bufio.NewReader(conn)
+	for range 2 {
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		readResponse(t, r)
+	}
+	conn.Close()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states) > 0 && states[len(states)-1] == StateClosed
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []ConnState{
+		StateNew,
+		StateReadingHeaders, StateActive, StateIdle,
+		StateReadingHeaders, StateActive, StateIdle,
+		StateClosed,
+	}, states)
+	for _, info := range infos {
+		assert.Equal(t, uint64(1), info.ID)
+		assert.Equal(t, conn.LocalAddr().String(), info.RemoteAddr)
+	}
+	assert.Equal(t, "reading headers", StateReadingHeaders.String())
+}
+
+func TestHijack(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			conn, rw, err := w.Hijack()
+			if err != nil {
+				w.Write([]byte(err.Error()))
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo: " + line)
+			rw.Flush()
+			_, err = w.Write([]byte("late"))
+			rw.WriteString(err.Error() + "\n")
+			rw.Flush()
+		},
+		ConnState: func(info ConnInfo, state ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		},
+	}
+	addr := startServer(t, s)
+
+	// Test: Bytes sent after the request reach the hijacker
+	conn := dial(t, addr)
+	_, err := io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: echo\r\n\r\nhello\n")
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n\r\necho: hello\n"+response.ErrHijacked.Error()+"\n", string(resp))
+
+	// Test: The server stops tracking the connection
+	assert.Equal(t, 0, s.Stats().Conns)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []ConnState{StateNew, StateReadingHeaders, StateActive, StateHijacked}, states)
+}
+
+// closeRecorder records whether a connection was closed.
+type closeRecorder struct {
+	net.Conn
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.Conn.Close()
+}
+
+func TestHijackFlushError(t *testing.T) {
+	s := &Server{}
+	client, pipe := net.Pipe()
+	client.Close()
+	server := &closeRecorder{Conn: pipe}
+	c := s.newConn(server)
+	require.True(t, s.addConn(c))
+	c.setState(StateActive)
+	c.bw.WriteString("HTTP/1.1 103 Early Hints\r\n\r\n")
+
+	// Test: A failed flush leaves the connection with the server
+	_, _, err := c.hijack()
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.False(t, c.hijacked())
+	assert.Equal(t, 1, s.Stats().Conns)
+
+	// Test: The server still closes it
+	c.close()
+	assert.Equal(t, 0, s.Stats().Conns)
+	assert.True(t, server.closed)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrorHandler is a handler that may fail. If it returns an error before
+// writing anything, an error response is sent in its place.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// ErrorRenderer produces the Content-Type and body of an error response.
+type ErrorRenderer func(herr HandlerError) (contentType string, body []byte)
+
+// HandlerError is an error that carries the response to send for it.
+type HandlerError struct {
+	StatusCode response.StatusCode
+	Message    string
+	// Headers are sent with the error response, such as Allow for a 405.
+	Headers headers.Headers
+}
+
+func (h HandlerError) Error() string {
+	if h.Message == "" {
+		return response.StatusText(h.StatusCode)
+	}
+	return h.Message
+}
+
+// Write writes h to w as a complete plain text response.
+func (h HandlerError) Write(w io.Writer) error {
+	writer := response.NewWriter(w)
+	if err := writeError(writer, h, DefaultErrorRenderer); err != nil {
+		return err
+	}
+	return writer.Finish()
+}
+
+// DefaultErrorRenderer renders the error message, or the reason phrase if
+// there is none, as plain text.
+func DefaultErrorRenderer(herr HandlerError) (string, []byte) {
+	return "text/plain", []byte(herr.Error() + "\n")
+}
+
+// HandleErrors adapts h to a Handler. A HandlerError returned by h is sent
+// as is, while any other error is logged and answered with a 500 so that its
+// details are not leaked to the client. If h already sent its status line
+// the error can only be logged. render may be nil to use
+// DefaultErrorRenderer.
+func HandleErrors(h ErrorHandler, render ErrorRenderer) Handler {
+	if render == nil {
+		render = DefaultErrorRenderer
+	}
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		var herr HandlerError
+		if !errors.As(err, &herr) {
+			log.Printf("Error handling %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			herr = HandlerError{StatusCode: response.StatusInternalServerError}
+		}
+		if w.Written() {
+			log.Printf("Error after response to %s %s was started: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			return
+		}
+		if err := writeError(w, herr, render); err != nil {
+			log.Printf("Error writing error response: %v", err)
+		}
+	}
+}
+
+// writeError replaces whatever w has buffered with the rendered error.
+func writeError(w *response.Writer, herr HandlerError, render ErrorRenderer) error {
+	if err := w.Reset(); err != nil {
+		return err
+	}
+	contentType, body := render(herr)
+	if err := w.WriteStatusLine(herr.StatusCode); err != nil {
+		return err
+	}
+	for key, val := range herr.Headers {
+		w.Header().Replace(key, val)
+	}
+	w.Header().Replace("Content-Type", contentType)
+	_, err := w.Write(body)
+	return err
+}
+
+func (s *Server) errorRenderer() ErrorRenderer {
+	if s.ErrorRenderer == nil {
+		return DefaultErrorRenderer
+	}
+	return s.ErrorRenderer
+}
+
+// lingerTimeout bounds how long the server keeps reading from a client it
+// has rejected, so the client gets to read the error response instead of
+// seeing its unread request data trigger a reset.
+const lingerTimeout = 500 * time.Millisecond
+
+// maxLingerBytes is how much unread request data is drained before giving
+// up on a rejected client.
+const maxLingerBytes = 256 << 10
+
+// rejectRequest answers a request that could not be parsed with an error
+// response chosen from the parse error, then closes the connection.
+func (s *Server) rejectRequest(conn net.Conn, err error) {
+	statusCode, ok := statusForParseError(err)
+	if !ok {
+		// the client went away or the connection failed, there is nobody
+		// to answer
+		return
+	}
+	s.logf("Rejecting request from %s: %v", conn.RemoteAddr(), err)
+	s.closeWithError(conn, HandlerError{StatusCode: statusCode})
+}
+
+// closeWithError sends herr on conn, then closes it.
+func (s *Server) closeWithError(conn net.Conn, herr HandlerError) {
+	defaults := s.responseDefaults()
+	defaults.Replace("Connection", "close")
+	conn.SetWriteDeadline(deadline(s.WriteTimeout))
+	w := response.NewWriter(conn)
+	w.SetDefaultHeaders(defaults)
+	if err := writeError(w, herr, s.errorRenderer()); err != nil {
+		return
+	}
+	if err := w.Finish(); err != nil {
+		return
+	}
+	closeLingering(conn)
+}
+
+// statusForParseError picks the status code for a request parse error. It
+// reports false for errors that leave no client to respond to.
+func statusForParseError(err error) (response.StatusCode, bool) {
+	switch {
+	case errors.Is(err, request.ErrNoRequest), errors.Is(err, request.ErrIncompleteRequest):
+		return 0, false
+	case errors.Is(err, request.ErrRequestTimeout):
+		return response.StatusRequestTimeout, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported, true
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge, true
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.StatusNotImplemented, true
+	case errors.Is(err, request.ErrMalformedRequestLine),
+		errors.Is(err, request.ErrMalformedHeader),
+		errors.Is(err, request.ErrInvalidContentLength),
+		errors.Is(err, request.ErrInvalidHost):
+		return response.StatusBadRequest, true
+	default:
+		return 0, false
+	}
+}
+
+// closeLingering shuts down the sending side of conn and drains what the
+// client is still sending for a short while before closing it.
+func closeLingering(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, io.LimitReader(conn, maxLingerBytes))
+}
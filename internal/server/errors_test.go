@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/responsetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHandleErrors(t *testing.T) {
+	req, err := responsetest.NewRequest("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: HandlerError replaces a buffered body
+	handler := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.Header().Set("X-Partial", "yes")
+		w.Write([]byte("never sent"))
+		return fmt.Errorf("lookup failed: %w", HandlerError{StatusCode: response.StatusNotFound, Message: "no such thing"})
+	}, nil)
+	rec := responsetest.NewRecorder()
+	handler(rec.Writer, req)
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "text/plain", res.Headers["content-type"])
+	assert.Equal(t, "14", res.Headers["content-length"])
+	assert.Equal(t, "no such thing\n", string(res.Body))
+	_, ok := res.Headers.Get("X-Partial")
+	assert.False(t, ok)
+
+	// Test: Other errors become a 500 without leaking details
+	handler = HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return errors.New("database password is hunter2")
+	}, func(herr HandlerError) (string, []byte) {
+		return "application/json", []byte(fmt.Sprintf(`{"status":%d}`, herr.StatusCode))
+	})
+	rec = responsetest.NewRecorder()
+	handler(rec.Writer, req)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, "application/json", res.Headers["content-type"])
+	assert.Equal(t, `{"status":500}`, string(res.Body))
+
+	// Test: Errors after the response started leave it untouched
+	handler = HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		return HandlerError{StatusCode: response.StatusTeapot}
+	}, nil)
+	rec = responsetest.NewRecorder()
+	handler(rec.Writer, req)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+}
+
+func TestHandlerErrorWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := HandlerError{StatusCode: response.StatusServiceUnavailable}.Write(buf)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 503 Service Unavailable\r\n")))
+	assert.Contains(t, buf.String(), "content-type: text/plain\r\n")
+	assert.Contains(t, buf.String(), "content-length: 20\r\n")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nService Unavailable\n")))
+
+	// Test: Extra headers are sent with the error
+	buf.Reset()
+	h := headers.NewHeaders()
+	h.Set("Allow", "GET, HEAD")
+	err = HandlerError{StatusCode: response.StatusMethodNotAllowed, Headers: h}.Write(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "allow: GET, HEAD\r\n")
+}
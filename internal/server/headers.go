@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"sync/atomic"
+	"time"
+)
+
+// dateFormat is the IMF-fixdate format required for the Date header.
+const dateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// dateCache formats the Date header at most once per second, since every
+// response needs it.
+type dateCache struct {
+	current atomic.Pointer[cachedDate]
+}
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+func (c *dateCache) get(now time.Time) string {
+	if cached := c.current.Load(); cached != nil && cached.unix == now.Unix() {
+		return cached.value
+	}
+	cached := &cachedDate{
+		unix:  now.Unix(),
+		value: now.UTC().Format(dateFormat),
+	}
+	c.current.Store(cached)
+	return cached.value
+}
+
+// defaultContentType is sent with responses when neither the handler nor
+// DefaultHeaders set a Content-Type.
+const defaultContentType = "text/plain"
+
+// responseDefaults returns the headers every response from s carries unless
+// the handler overrides them.
+func (s *Server) responseDefaults() headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", defaultContentType)
+	for key, val := range s.DefaultHeaders {
+		h.Replace(key, val)
+	}
+	h.Replace("Date", s.dates.get(time.Now()))
+	if s.ServerName != "" {
+		h.Replace("Server", s.ServerName)
+	}
+	return h
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDateCache(t *testing.T) {
+	cache := &dateCache{}
+	now := time.Date(2024, time.March, 5, 14, 30, 9, 0, time.FixedZone("EST", -5*60*60))
+
+	// Test: IMF-fixdate in GMT
+	assert.Equal(t, "Tue, 05 Mar 2024 19:30:09 GMT", cache.get(now))
+
+	// Test: Same second is served from the cache
+	first := cache.current.Load()
+	cache.get(now.Add(500 * time.Millisecond))
+	assert.Same(t, first, cache.current.Load())
+
+	// Test: Next second is reformatted
+	assert.Equal(t, "Tue, 05 Mar 2024 19:30:10 GMT", cache.get(now.Add(time.Second)))
+}
+
+func TestResponseDefaults(t *testing.T) {
+	defaults := headers.NewHeaders()
+	defaults.Set("Content-Type", "text/html")
+	s := &Server{}
+	WithServerName("httpfromtcp")(s)
+	WithDefaultHeaders(defaults)(s)
+
+	h := s.responseDefaults()
+	assert.Equal(t, "httpfromtcp", h["server"])
+	assert.Equal(t, "text/html", h["content-type"])
+	assert.NotEmpty(t, h["date"])
+
+	// Test: No Server header unless configured, and plain text by default
+	h = (&Server{}).responseDefaults()
+	_, ok := h.Get("Server")
+	assert.False(t, ok)
+	assert.Equal(t, "text/plain", h["content-type"])
+}
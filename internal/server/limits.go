@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"net"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is the Retry-After sent with 503s for rejected
+// connections when Server.RetryAfter is not set.
+const defaultRetryAfter = time.Second
+
+// maxRejecting caps how many over-limit connections are answered with a 503
+// at once. Each holds a goroutine and a file descriptor while the response
+// is written and the client's request drained, so during a flood the rest
+// are closed straight away.
+const maxRejecting = 64
+
+// Stats is a snapshot of how much of its limits a server is using.
+type Stats struct {
+	// Conns is the number of open connections.
+	Conns int
+	// IdleConns is the number of open connections waiting for a request.
+	IdleConns int
+	// Handlers is the number of handlers running.
+	Handlers int
+	// WaitingHandlers is the number of requests waiting for a handler slot.
+	WaitingHandlers int
+	// RejectedConns counts the connections turned away because the server
+	// was at MaxConns, whether answered with a 503 or closed outright.
+	RejectedConns uint64
+}
+
+// Stats returns the server's current usage.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		Conns:           len(s.conns),
+		Handlers:        int(s.handlers.Load()),
+		WaitingHandlers: int(s.waitingHandlers.Load()),
+		RejectedConns:   s.rejectedConns.Load(),
+	}
+	for c := range s.conns {
+		if ConnState(c.state.Load()) == StateIdle {
+			stats.IdleConns++
+		}
+	}
+	return stats
+}
+
+// initLimits creates the semaphores for MaxConns and MaxConcurrentHandlers.
+// s.mu must be held.
+func (s *Server) initLimits() {
+	if s.MaxConns > 0 && s.connSlots == nil {
+		s.connSlots = make(chan struct{}, s.MaxConns)
+	}
+	if s.MaxConcurrentHandlers > 0 && s.handlerSlots == nil {
+		s.handlerSlots = make(chan struct{}, s.MaxConcurrentHandlers)
+	}
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	if s.killed == nil {
+		s.killed = make(chan struct{})
+	}
+}
+
+// waitConnSlot blocks until a connection may be accepted, reporting whether
+// it took a connection slot for it and false for ok if the server closed in
+// the meantime. It neither blocks nor takes a slot when over-limit
+// connections are rejected instead; admitConn takes one after Accept.
+func (s *Server) waitConnSlot() (took, ok bool) {
+	if s.connSlots == nil || s.RejectExcessConns {
+		return false, true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true, true
+	case <-s.done:
+		return false, false
+	}
+}
+
+// admitConn takes a connection slot for a newly accepted connection when
+// over-limit connections are rejected, answering it with a 503, or closing
+// it if maxRejecting 503s are already being sent, and reporting false if
+// there is none free.
+func (s *Server) admitConn(conn net.Conn) bool {
+	if s.connSlots == nil || !s.RejectExcessConns {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+	}
+	s.rejectedConns.Add(1)
+	if s.rejecting.Add(1) > maxRejecting {
+		s.rejecting.Add(-1)
+		conn.Close()
+		return false
+	}
+	go func() {
+		defer s.rejecting.Add(-1)
+		defer conn.Close()
+		retryAfter := s.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultRetryAfter
+		}
+		h := headers.NewHeaders()
+		h.Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		s.closeWithError(conn, HandlerError{StatusCode: response.StatusServiceUnavailable, Headers: h})
+	}()
+	return false
+}
+
+func (s *Server) releaseConnSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// acquireHandler waits for a free handler slot, reporting false if the
+// server was closed first. A graceful Shutdown does not stop the wait, so
+// requests already read still get handled. Every successful call must be
+// paired with releaseHandler.
+func (s *Server) acquireHandler() bool {
+	if s.handlerSlots != nil {
+		select {
+		case s.handlerSlots <- struct{}{}:
+		default:
+			s.waitingHandlers.Add(1)
+			defer s.waitingHandlers.Add(-1)
+			select {
+			case s.handlerSlots <- struct{}{}:
+			case <-s.killed:
+				return false
+			}
+		}
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) releaseHandler() {
+	s.handlers.Add(-1)
+	if s.handlerSlots != nil {
+		<-s.handlerSlots
+	}
+}
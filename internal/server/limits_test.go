@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if s.Logger == nil {
+		s.Logger = log.New(io.Discard, "", 0)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	require.Eventually(t, func() bool { return s.ListenAddr() != nil }, time.Second, time.Millisecond)
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestMaxConns(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	// Test: Connections over the limit are rejected with 503
+	s := &Server{Handler: handler, MaxConns: 1, RejectExcessConns: true, RetryAfter: 1500 * time.Millisecond}
+	addr := startServer(t, s)
+	held := dial(t, addr)
+	require.Eventually(t, func() bool { return s.Stats().Conns == 1 }, time.Second, time.Millisecond)
+	resp, err := io.ReadAll(dial(t, addr))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 503 Service Unavailable\r\n"), string(resp))
+	assert.Contains(t, string(resp), "retry-after: 2\r\n")
+	assert.Equal(t, uint64(1), s.Stats().RejectedConns)
+
+	// Test: Past maxRejecting concurrent 503s, connections are just closed
+	s.rejecting.Store(maxRejecting)
+	resp, err = io.ReadAll(dial(t, addr))
+	require.NoError(t, err)
+	assert.Empty(t, resp)
+	assert.Equal(t, uint64(2), s.Stats().RejectedConns)
+	assert.Equal(t, int64(maxRejecting), s.rejecting.Load())
+	s.rejecting.Store(0)
+	held.Close()
+
+	// Test: Accepting pauses at the limit until a connection closes
+	s = &Server{Handler: handler, MaxConns: 1}
+	addr = startServer(t, s)
+	held = dial(t, addr)
+	require.Eventually(t, func() bool { return s.Stats().Conns == 1 }, time.Second, time.Millisecond)
+	queued := dial(t, addr)
+	_, err = io.WriteString(queued, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	queued.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = queued.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	held.Close()
+	queued.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.True(t, strings.HasSuffix(readResponse(t, bufio.NewReader(queued)), "\r\n\r\nok"))
+}
+
+func TestMaxConnsClose(t *testing.T) {
+	// Test: Close stops Serve when no connection slot was ever taken
+	s := &Server{MaxConns: 2, RejectExcessConns: true, Logger: log.New(io.Discard, "", 0)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	require.Eventually(t, func() bool { return s.ListenAddr() != nil }, time.Second, time.Millisecond)
+	require.NoError(t, s.Close())
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
+
+func TestMaxConcurrentHandlers(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			<-release
+			w.Write([]byte("ok"))
+		},
+		MaxConcurrentHandlers: 1,
+	}
+	addr := startServer(t, s)
+
+	var readers []*bufio.Reader
+	for range 2 {
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		readers = append(readers, bufio.NewReader(conn))
+	}
+
+	// Test: The second request waits for the first handler
+	require.Eventually(t, func() bool {
+		stats := s.Stats()
+		return stats.Handlers == 1 && stats.WaitingHandlers == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, s.Stats().Conns)
+
+	// Test: Both requests complete once handlers are released
+	close(release)
+	for _, r := range readers {
+		assert.True(t, strings.HasSuffix(readResponse(t, r), "\r\n\r\nok"))
+	}
+	require.Eventually(t, func() bool {
+		stats := s.Stats()
+		return stats.Handlers == 0 && stats.IdleConns == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestMaxConcurrentHandlersShutdown(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			<-release
+			w.Write([]byte("ok"))
+		},
+		MaxConcurrentHandlers: 1,
+	}
+	addr := startServer(t, s)
+
+	var readers []*bufio.Reader
+	for range 2 {
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		readers = append(readers, bufio.NewReader(conn))
+	}
+	require.Eventually(t, func() bool {
+		return s.Stats().WaitingHandlers == 1
+	}, time.Second, time.Millisecond)
+
+	// Test: A request waiting for a handler is still served on shutdown
+	shutdownDone := make(chan error)
+	go func() {
+		shutdownDone <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, s.Stats().WaitingHandlers)
+	close(release)
+	for _, r := range readers {
+		resp := readResponse(t, r)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+		assert.Contains(t, resp, "connection: close\r\n")
+	}
+	require.NoError(t, <-shutdownDone)
+}
+
+func TestMaxConcurrentHandlersFreeSlotAfterShutdown(t *testing.T) {
+	s := &Server{MaxConcurrentHandlers: 1}
+	s.initLimits()
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	// Test: A free slot is always taken, even once done is closed
+	for range 100 {
+		require.True(t, s.acquireHandler())
+		s.releaseHandler()
+	}
+
+	// Test: Close stops the wait for a busy slot
+	require.True(t, s.acquireHandler())
+	go s.Close()
+	assert.False(t, s.acquireHandler())
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"log"
+	"time"
+)
+
+// Middleware wraps a Handler with behavior shared by many handlers, such as
+// logging or authentication.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in mw. The first middleware is the outermost, so it sees
+// each request first and the response last.
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// AccessLog logs one line per completed response with the client address,
+// status code, body size and duration. The client address is the request's
+// ClientAddr when forwarding headers have been resolved, and its RemoteAddr
+// otherwise. A nil logger means the standard logger.
+func AccessLog(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			observer := response.Observe(w)
+			// headers of a buffered body are only sent when the server
+			// finishes the response after the handler returns
+			w.OnFinish(func() {
+				client := req.ClientAddr
+				if client == "" {
+					client = req.RemoteAddr
+				}
+				logger.Printf("%s %s %s %d %d %s", client, req.RequestLine.Method, req.RequestLine.RequestTarget, observer.Status(), observer.BytesWritten(), time.Since(start))
+			})
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/responsetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"strings"
+	"testing"
+)
+
+// tag is a middleware that records its name on the way in and out.
+func tag(name string, trace *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			*trace = append(*trace, name+" in")
+			next(w, req)
+			*trace = append(*trace, name+" out")
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		trace = append(trace, "handler")
+	}, tag("outer", &trace), tag("inner", &trace))
+
+	req, err := responsetest.NewRequest("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	h(responsetest.NewRecorder().Writer, req)
+	assert.Equal(t, []string{"outer in", "inner in", "handler", "inner out", "outer out"}, trace)
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := &strings.Builder{}
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCreated)
+		w.Write([]byte("created"))
+	}, AccessLog(log.New(logs, "", 0)))
+
+	// Test: The buffered response is logged once it is finished
+	req, err := responsetest.NewRequest("POST /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	h(rec.Writer, req)
+	assert.Empty(t, logs.String())
+	_, err = rec.Result()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(logs.String(), "192.0.2.1:1234 POST /items 201 7 "), logs.String())
+
+	// Test: The resolved client address is logged instead of the peer's
+	logs.Reset()
+	req, err = responsetest.NewRequest("GET /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	req.ClientAddr = "203.0.113.9"
+	rec = responsetest.NewRecorderFor(req)
+	h(rec.Writer, req)
+	_, err = rec.Result()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(logs.String(), "203.0.113.9 GET /items 201 7 "), logs.String())
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/headers"
+	"log"
+	"time"
+)
+
+// Option sets a Server field when using the Serve shorthand.
+type Option func(*Server)
+
+// WithServerName sends name in the Server header of every response that
+// does not set its own.
+func WithServerName(name string) Option {
+	return func(s *Server) {
+		s.ServerName = name
+	}
+}
+
+// WithDefaultHeaders adds h to every response that does not set the same
+// fields itself.
+func WithDefaultHeaders(h headers.Headers) Option {
+	return func(s *Server) {
+		s.DefaultHeaders = h
+	}
+}
+
+// WithErrorRenderer renders the error responses the server sends on its own,
+// such as the 500 sent when a handler panics.
+func WithErrorRenderer(render ErrorRenderer) Option {
+	return func(s *Server) {
+		s.ErrorRenderer = render
+	}
+}
+
+// WithLogger sends the server's log output to logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.Logger = logger
+	}
+}
+
+// WithReadHeaderTimeout bounds reading each request's line and headers.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.ReadHeaderTimeout = timeout
+	}
+}
+
+// WithReadBodyTimeout bounds reading each request's body.
+func WithReadBodyTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.ReadBodyTimeout = timeout
+	}
+}
+
+// WithWriteTimeout bounds handling each request and writing its response.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.WriteTimeout = timeout
+	}
+}
+
+// WithIdleTimeout bounds how long a kept-alive connection waits for its next
+// request.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = timeout
+	}
+}
+
+// WithMaxConns limits the number of open connections, pausing Accept once
+// the limit is reached.
+func WithMaxConns(n int) Option {
+	return func(s *Server) {
+		s.MaxConns = n
+	}
+}
+
+// WithRejectExcessConns answers connections over the MaxConns limit with
+// 503 Service Unavailable and Retry-After instead of pausing Accept.
+func WithRejectExcessConns(retryAfter time.Duration) Option {
+	return func(s *Server) {
+		s.RejectExcessConns = true
+		s.RetryAfter = retryAfter
+	}
+}
+
+// WithMaxConcurrentHandlers limits how many handlers run at once.
+func WithMaxConcurrentHandlers(n int) Option {
+	return func(s *Server) {
+		s.MaxConcurrentHandlers = n
+	}
+}
+
+// WithAcceptErrorHandler calls fn for every failed Accept with the number of
+// consecutive failures.
+func WithAcceptErrorHandler(fn func(err error, failures int)) Option {
+	return func(s *Server) {
+		s.OnAcceptError = fn
+	}
+}
+
+// WithConnState calls hook whenever a connection changes state.
+func WithConnState(hook func(info ConnInfo, state ConnState)) Option {
+	return func(s *Server) {
+		s.ConnState = hook
+	}
+}
+
+// WithOptionsHandler answers OPTIONS * requests with h, such as a router's
+// ServeHTTP so that the response lists the methods it supports.
+func WithOptionsHandler(h Handler) Option {
+	return func(s *Server) {
+		s.OptionsHandler = h
+	}
+}
@@ -1,76 +1,381 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/headers"
 	"github.com/jmservic/httpfromtcp/internal/request"
 	"github.com/jmservic/httpfromtcp/internal/response"
-	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// Server is an HTTP 1.1 server
+// ErrServerClosed is returned by Serve and ListenAndServe once the server
+// has been closed.
+var ErrServerClosed = errors.New("server closed")
+
+// Server is an HTTP 1.1 server. The exported fields configure it and must
+// not be changed once it is serving.
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	closed   atomic.Bool
-}
+	// Addr is the TCP address ListenAndServe listens on, such as ":42069"
+	// for all interfaces or "[::1]:42069". Empty means ":80".
+	Addr    string
+	Handler Handler
+	// OptionsHandler answers OPTIONS * requests, which ask about the
+	// server as a whole rather than a resource. Nil means a 200 with no
+	// body. Other methods cannot use the asterisk target and are answered
+	// with 400 Bad Request.
+	OptionsHandler Handler
 
-type Handler func(w *response.Writer, req *request.Request)
+	// ServerName is sent in the Server header of responses that do not set
+	// their own.
+	ServerName string
+	// DefaultHeaders are added to every response that does not set the same
+	// fields itself. Responses without a Content-Type from either are sent
+	// as text/plain.
+	DefaultHeaders headers.Headers
+	// ErrorRenderer renders the error responses the server sends on its
+	// own, such as for malformed requests. Nil means DefaultErrorRenderer.
+	ErrorRenderer ErrorRenderer
 
-type HandlerError struct {
-	StatusCode response.StatusCode
-	Message    string
-}
+	// MaxHeaderBytes limits the request line and headers of a request.
+	// Zero means request.DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the Content-Length a request may declare. Zero
+	// means request.DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+
+	// ReadHeaderTimeout bounds reading a request's line and headers, counted
+	// from when the connection is accepted or the request's first byte
+	// arrives on a kept-alive connection. Requests that miss it are answered
+	// with 408 Request Timeout. Zero means no limit.
+	ReadHeaderTimeout time.Duration
+	// ReadBodyTimeout bounds reading a request's body once its headers are
+	// in. Zero means no limit.
+	ReadBodyTimeout time.Duration
+	// WriteTimeout bounds handling a request and writing its response,
+	// counted from when the request has been read. Zero means no limit.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long a kept-alive connection waits for its
+	// next request. Zero means ReadHeaderTimeout is used.
+	IdleTimeout time.Duration
+
+	// MaxConns limits the number of open connections. Once it is reached
+	// the server stops accepting until a connection closes, or, if
+	// RejectExcessConns is set, answers new connections with 503 Service
+	// Unavailable. Zero means no limit.
+	MaxConns int
+	// RejectExcessConns turns connections over MaxConns away instead of
+	// leaving them queued in the listener.
+	RejectExcessConns bool
+	// RetryAfter is sent in the Retry-After header of rejected connections,
+	// rounded up to whole seconds. Zero means one second.
+	RetryAfter time.Duration
+	// MaxConcurrentHandlers limits how many handlers run at once. Requests
+	// over the limit wait for a running handler to finish. Zero means no
+	// limit.
+	MaxConcurrentHandlers int
+
+	// OnAcceptError, if set, is called for every failed Accept with the
+	// number of consecutive failures, so a supervisor can tell when the
+	// listener is persistently failing.
+	OnAcceptError func(err error, failures int)
+
+	// ConnState, if set, is called whenever a connection changes state,
+	// from the goroutine serving it or the one accepting it. It must not
+	// block.
+	ConnState func(info ConnInfo, state ConnState)
+
+	// Logger receives connection errors and recovered panics. Nil means the
+	// standard logger.
+	Logger *log.Logger
+
+	mu         sync.Mutex
+	listener   net.Listener
+	conns      map[*conn]struct{}
+	closed     atomic.Bool
+	inShutdown atomic.Bool
+	dates      dateCache
+	// done is closed once the server is closed
+	done chan struct{}
+	// killed is closed by Close but not Shutdown, for work that only gives
+	// up when connections are torn down
+	killed chan struct{}
+	// connsChanged is signalled when a connection changes state during
+	// Shutdown, so it can check again for idle connections
+	connsChanged chan struct{}
+
+	nextConnID atomic.Uint64
 
-func (h HandlerError) Write(w io.Writer) error {
-	_, err := fmt.Fprintf(w, "HTTP/1.1 %d \r\n\r\n%s", h.StatusCode, h.Message)
-	return err
+	connSlots       chan struct{}
+	handlerSlots    chan struct{}
+	handlers        atomic.Int64
+	waitingHandlers atomic.Int64
+	rejectedConns   atomic.Uint64
+	// rejecting counts the 503s for over-limit connections being sent
+	rejecting atomic.Int64
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	socket, err := net.Listen("tcp", addr)
+type Handler func(w *response.Writer, req *request.Request)
+
+// Serve starts a server for handler on 127.0.0.1:port in the background.
+// It is a shorthand for configuring a Server and calling its Serve method.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	s := &Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", port),
+		Handler: handler,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	socket, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating listener: %s", err)
 	}
 
-	s := &Server{
-		listener: socket,
-		handler:  handler,
-	}
-	go s.listen()
+	s.setListener(socket)
+	go s.Serve(socket)
 	return s, nil
 }
 
-func (s *Server) Close() error {
-	s.closed.Store(true)
-	return s.listener.Close()
+// ListenAndServe listens on s.Addr and serves connections until the server
+// is closed.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":80"
+	}
+	socket, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Error creating listener: %s", err)
+	}
+	return s.Serve(socket)
 }
 
-func (s *Server) listen() {
+// Serve accepts connections on l until the server is closed or Accept fails
+// with an error that is not temporary, and always returns a non-nil error.
+// Temporary errors are retried with exponential backoff. l is closed when
+// Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if !s.setListener(l) {
+		return ErrServerClosed
+	}
+	var backoff acceptBackoff
 	for {
-		conn, err := s.listener.Accept()
+		tookSlot, ok := s.waitConnSlot()
+		if !ok {
+			return ErrServerClosed
+		}
+		conn, err := l.Accept()
 		if err != nil {
+			if tookSlot {
+				s.releaseConnSlot()
+			}
 			if s.closed.Load() {
-				return
+				return ErrServerClosed
 			}
-			log.Printf("Error accepting connection: %v", err)
+			if backoff.failed(s, err) {
+				continue
+			}
+			return err
+		}
+		backoff.succeeded()
+		if !s.admitConn(conn) {
 			continue
 		}
-		go s.handle(conn)
+		c := s.newConn(conn)
+		if !s.addConn(c) {
+			// a shutdown or close began while the connection was being
+			// accepted and has already passed over the tracked set
+			conn.Close()
+			s.releaseConnSlot()
+			return ErrServerClosed
+		}
+		go c.serve()
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		response.WriteStatusLine(conn, response.StatusBadRequest)
+// Close immediately closes the listener and every connection, including
+// those with requests in flight. See Shutdown for a graceful alternative.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+	if s.killed != nil {
+		select {
+		case <-s.killed:
+		default:
+			close(s.killed)
+		}
+	}
+	for c := range s.conns {
+		c.rwc.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// addConn starts tracking a newly accepted connection in StateNew,
+// reporting false if the server has been closed, in which case the
+// connection must not be served.
+func (s *Server) addConn(c *conn) bool {
+	s.mu.Lock()
+	if s.closed.Load() {
+		s.mu.Unlock()
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[*conn]struct{}{}
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	s.connStateHook(c, StateNew)
+	return true
+}
+
+// trackConn records the connections the server has open, so they can be
+// closed on shutdown.
+func (s *Server) trackConn(c *conn, state ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = map[*conn]struct{}{}
+	}
+	if state == StateClosed || state == StateHijacked {
+		delete(s.conns, c)
+	} else {
+		s.conns[c] = struct{}{}
+	}
+	if s.connsChanged != nil {
+		select {
+		case s.connsChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ListenAddr returns the address the server is listening on, or nil if it is not
+// serving yet.
+func (s *Server) ListenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// setListener records l as the server's listener, reporting false if the
+// server has already been closed.
+func (s *Server) setListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.initLimits()
+	s.listener = l
+	return true
+}
+
+// closeLocked marks the server closed. s.mu must be held.
+func (s *Server) closeLocked() {
+	if s.closed.Swap(true) {
 		return
 	}
+	if s.done != nil {
+		close(s.done)
+	}
+}
+
+func (s *Server) connStateHook(c *conn, state ConnState) {
+	if s.ConnState != nil {
+		s.ConnState(c.info, state)
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
 
-	writer := response.NewWriter(conn)
-	s.handler(writer, req)
+// runHandler calls the handler, recovering from any panic so that one bad
+// request cannot take down the process. A panic before the response was
+// started is answered with a 500. It reports false if the panic happened
+// mid-response and the connection has to be aborted instead.
+func (s *Server) runHandler(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		s.logf("Panic serving %s %s for %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RemoteAddr, v, debug.Stack())
+		if w.Written() {
+			return
+		}
+		err := writeError(w, HandlerError{StatusCode: response.StatusInternalServerError}, s.errorRenderer())
+		if err != nil {
+			s.logf("Error writing error response: %v", err)
+		}
+		ok = err == nil
+	}()
+	s.handlerFor(req)(w, req)
+	return true
+}
+
+// handlerFor picks the handler for req, diverting asterisk-form targets
+// away from the main handler.
+func (s *Server) handlerFor(req *request.Request) Handler {
+	if req.RequestLine.RequestTarget != "*" {
+		return s.Handler
+	}
+	if req.RequestLine.Method != "OPTIONS" {
+		return func(w *response.Writer, req *request.Request) {
+			herr := HandlerError{StatusCode: response.StatusBadRequest, Message: "The * target is only valid for OPTIONS."}
+			if err := writeError(w, herr, s.errorRenderer()); err != nil {
+				s.logf("Error writing error response: %v", err)
+			}
+		}
+	}
+	if s.OptionsHandler != nil {
+		return s.OptionsHandler
+	}
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+	}
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout != 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadHeaderTimeout
+}
+
+// deadline returns the deadline for a timeout starting now, or the zero
+// time, meaning no deadline, if timeout is not positive.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// abort closes conn with a reset where possible, so the client sees the
+// response fail instead of mistaking a truncated body for a complete one.
+func abort(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
 }
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTrip sends raw to the server and returns everything it answers
+// until the connection is closed.
+func roundTrip(t *testing.T, s *Server, raw string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	// signal that no more requests follow, so the server closes the
+	// connection after answering
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err := io.ReadAll(conn)
+	return string(resp), err
+}
+
+func TestPanicRecovery(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/late" {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(100))
+			w.WriteBody([]byte("partial"))
+		}
+		var m map[string]int
+		m["boom"]++
+	}, WithLogger(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Panic before writing is answered with a 500
+	resp, err := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"), resp)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nInternal Server Error\n"), resp)
+
+	// Test: The server keeps serving after a panic
+	resp, err = roundTrip(t, s, "GET /again HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 "), resp)
+
+	// Test: Panic mid-response aborts the connection
+	resp, _ = roundTrip(t, s, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.NotContains(t, resp, "Internal Server Error")
+}
+
+func TestMalformedRequests(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		t.Errorf("handler called for %s", req.RequestLine.RequestTarget)
+	}, WithLogger(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Malformed header gets a complete 400
+	resp, err := roundTrip(t, s, "GET / HTTP/1.1\r\nHost localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	assert.Contains(t, resp, "connection: close\r\n")
+	assert.Contains(t, resp, "content-length: 12\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nBad Request\n"), resp)
+
+	// Test: Unsupported version
+	resp, err = roundTrip(t, s, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 505 HTTP Version Not Supported\r\n"), resp)
+
+	// Test: Missing and repeated Host headers
+	resp, err = roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	resp, err = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+
+	// Test: A chunked body is rejected instead of being read as a second
+	// request on the connection
+	smuggled := "GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	resp, err = roundTrip(t, s, "POST /public HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(smuggled), smuggled))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 501 Not Implemented\r\n"), resp)
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "), resp)
+	resp, err = roundTrip(t, s, "POST /public HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nTransfer-Encoding: chunked\r\n\r\n"+smuggled)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "), resp)
+
+	// Test: Unread request data does not reset the connection
+	resp, err = roundTrip(t, s, "BAD REQUEST LINE HERE\r\n"+strings.Repeat("x", 64<<10))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "), resp)
+}
+
+func TestServeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	logs := &strings.Builder{}
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			w.Write([]byte(req.RequestLine.RequestTarget))
+		},
+		MaxHeaderBytes: 64,
+		MaxBodyBytes:   4,
+		Logger:         log.New(logs, "", 0),
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	require.Eventually(t, func() bool { return s.ListenAddr() != nil }, time.Second, time.Millisecond)
+
+	// Test: Requests are served from the injected listener
+	resp, err := roundTrip(t, s, "GET /injected HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/injected"), resp)
+
+	// Test: Oversized headers
+	resp, err = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: "+strings.Repeat("a", 100)+"\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), resp)
+	assert.Contains(t, logs.String(), "Rejecting request")
+
+	// Test: Oversized body
+	resp, err = roundTrip(t, s, "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 Content Too Large\r\n"), resp)
+
+	// Test: Close stops Serve
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, <-done, ErrServerClosed)
+}
+
+func TestTimeouts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			w.Write([]byte(req.RequestLine.RequestTarget))
+		},
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		ReadBodyTimeout:   100 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+		Logger:            log.New(io.Discard, "", 0),
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	// dialSlow sends partial and then waits for the server to give up.
+	dialSlow := func(partial string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.WriteString(conn, partial)
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	// Test: Headers trickling in too slowly
+	resp := dialSlow("GET / HTTP/1.1\r\nHost: local")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 408 Request Timeout\r\n"), resp)
+	assert.Contains(t, resp, "connection: close\r\n")
+
+	// Test: Body never completed
+	resp = dialSlow("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n01234")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 408 Request Timeout\r\n"), resp)
+
+	// Test: A connection that never sends a request is closed silently
+	resp = dialSlow("")
+	assert.Empty(t, resp)
+
+	// Test: An idle kept-alive connection is closed after its response
+	resp = dialSlow("GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/idle"), resp)
+}
+
+func TestOptionsAsterisk(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		t.Errorf("handler called for %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget)
+	}, WithLogger(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The server answers OPTIONS * itself
+	resp, err := roundTrip(t, s, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.Contains(t, resp, "content-length: 0\r\n")
+
+	// Test: Other methods cannot use the asterisk
+	resp, err = roundTrip(t, s, "GET * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+
+	// Test: A configured options handler
+	s = &Server{
+		OptionsHandler: func(w *response.Writer, req *request.Request) {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			w.WriteStatusLine(response.StatusOK)
+		},
+	}
+	startServer(t, s)
+	resp, err = roundTrip(t, s, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, resp, "allow: GET, OPTIONS\r\n")
+}
+
+func TestExpectContinue(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.Write(req.Body)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	// Test: 100 Continue is sent before the body is read
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+	interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+	_, err = io.ReadFull(r, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	resp := readResponse(t, r)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello"), resp)
+
+	// Test: No 100 Continue when the body arrives with the headers
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nhi")
+	require.NoError(t, err)
+	resp = readResponse(t, r)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+)
+
+// Shutdown gracefully stops the server. It stops accepting connections,
+// closes connections that are idle, and waits for in-flight requests to
+// finish, closing each connection once its response is done. If ctx expires
+// first, the remaining connections are closed forcibly and ctx's error is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	s.closeLocked()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if s.connsChanged == nil {
+		s.connsChanged = make(chan struct{}, 1)
+	}
+	changed := s.connsChanged
+	s.mu.Unlock()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// closeIdleConns closes every connection that is not handling a request and
+// reports whether no connections remain.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if c.closeIfIdle() {
+			delete(s.conns, c)
+		}
+	}
+	return len(s.conns) == 0
+}
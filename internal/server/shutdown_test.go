@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readResponse reads one response with a Content-Length body from r.
+func readResponse(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var resp strings.Builder
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		resp.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+		if strings.HasPrefix(line, "content-length: ") {
+			_, err := fmt.Sscanf(line, "content-length: %d", &length)
+			require.NoError(t, err)
+		}
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	require.NoError(t, err)
+	resp.Write(body)
+	return resp.String()
+}
+
+func TestKeepAlive(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	// Test: Two requests on one connection
+	_, err = io.WriteString(conn, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp := readResponse(t, r)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/one"), resp)
+	assert.NotContains(t, resp, "connection: close")
+
+	_, err = io.WriteString(conn, "GET /two HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	resp = readResponse(t, r)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n/two"), resp)
+	assert.Contains(t, resp, "connection: close\r\n")
+
+	// Test: The server closes after Connection: close
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+		}
+		w.Write([]byte("done"))
+	})
+	require.NoError(t, err)
+	addr := s.ListenAddr().String()
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	idle.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, bufio.NewReader(idle))
+
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	busy.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(busy, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for c := range s.conns {
+			if ConnState(c.state.Load()) == StateActive {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	shutdownDone := make(chan error)
+	go func() {
+		shutdownDone <- s.Shutdown(context.Background())
+	}()
+
+	// Test: The idle keep-alive connection is closed
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: New connections are refused
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, time.Millisecond)
+
+	// Test: The in-flight request finishes before Shutdown returns
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	resp, err := io.ReadAll(busy)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(resp), "done"))
+	require.NoError(t, <-shutdownDone)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		time.Sleep(time.Second)
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// handoffListener returns the connections sent on conns from Accept.
+type handoffListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (l *handoffListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func (l *handoffListener) Close() error { return nil }
+
+func TestShutdownDuringAccept(t *testing.T) {
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("ok"))
+	}}
+	l := &handoffListener{conns: make(chan net.Conn)}
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.listener != nil
+	}, time.Second, time.Millisecond)
+
+	// Test: A connection accepted after Shutdown returned is not served
+	require.NoError(t, s.Shutdown(context.Background()))
+	client, server := net.Pipe()
+	defer client.Close()
+	l.conns <- server
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+	client.SetDeadline(time.Now().Add(time.Second))
+	_, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
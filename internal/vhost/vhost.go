@@ -0,0 +1,93 @@
+// Package vhost dispatches requests to different handlers by the host name
+// in their Host header, so one server can serve several sites.
+package vhost
+
+import (
+	"fmt"
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/server"
+	"strings"
+)
+
+// Hosts is a table of sites keyed by host name. Its ServeHTTP method is a
+// server.Handler. Sites must all be added before it starts serving.
+type Hosts struct {
+	// Default handles requests for hosts that match no site, including
+	// HTTP/1.0 requests without a Host header. Nil means a 421 Misdirected
+	// Request rendered with ErrorRenderer.
+	Default server.Handler
+	// ErrorRenderer renders the 421 responses sent when there is no
+	// Default. Nil means server.DefaultErrorRenderer.
+	ErrorRenderer server.ErrorRenderer
+
+	exact map[string]server.Handler
+	// wildcards maps the parent domain of a "*." pattern, such as
+	// "example.com" for "*.example.com", to its handler.
+	wildcards map[string]server.Handler
+}
+
+func New() *Hosts {
+	return &Hosts{
+		exact:     map[string]server.Handler{},
+		wildcards: map[string]server.Handler{},
+	}
+}
+
+// Handle serves requests for host with h. host is a host name such as
+// "example.com", matched case-insensitively and whatever the port, or a
+// wildcard such as "*.example.com" matching any subdomain at any depth but
+// not example.com itself. Exact names take precedence over wildcards, and
+// longer wildcards over shorter ones. Any name request.ValidHostName accepts
+// can be registered. Handle panics if host is malformed or already
+// registered.
+func (hs *Hosts) Handle(host string, h server.Handler) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if h == nil || !request.ValidHostName(strings.TrimPrefix(host, "*.")) {
+		panic(fmt.Sprintf("vhost: invalid host %q", host))
+	}
+	table := hs.exact
+	if parent, ok := strings.CutPrefix(host, "*."); ok {
+		table, host = hs.wildcards, parent
+	}
+	if _, ok := table[host]; ok {
+		panic(fmt.Sprintf("vhost: host %q is already registered", host))
+	}
+	table[host] = h
+}
+
+// ServeHTTP dispatches req to the site matching its host.
+func (hs *Hosts) ServeHTTP(w *response.Writer, req *request.Request) {
+	if h := hs.match(req.Host()); h != nil {
+		h(w, req)
+		return
+	}
+	if hs.Default != nil {
+		hs.Default(w, req)
+		return
+	}
+	server.HandleErrors(func(*response.Writer, *request.Request) error {
+		return server.HandlerError{StatusCode: response.StatusMisdirectedRequest}
+	}, hs.ErrorRenderer)(w, req)
+}
+
+// match finds the handler for host, trying the exact name and then each
+// parent domain's wildcard from the closest.
+func (hs *Hosts) match(host string) server.Handler {
+	if host == "" {
+		return nil
+	}
+	if h, ok := hs.exact[host]; ok {
+		return h
+	}
+	for {
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return nil
+		}
+		if h, ok := hs.wildcards[parent]; ok {
+			return h
+		}
+		host = parent
+	}
+}
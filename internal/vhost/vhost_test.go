@@ -0,0 +1,79 @@
+package vhost
+
+import (
+	"github.com/jmservic/httpfromtcp/internal/request"
+	"github.com/jmservic/httpfromtcp/internal/response"
+	"github.com/jmservic/httpfromtcp/internal/responsetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func site(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(name))
+	}
+}
+
+func serve(t *testing.T, hs *Hosts, raw string) *responsetest.Result {
+	t.Helper()
+	req, err := responsetest.NewRequest(raw)
+	require.NoError(t, err)
+	rec := responsetest.NewRecorderFor(req)
+	hs.ServeHTTP(rec.Writer, req)
+	res, err := rec.Result()
+	require.NoError(t, err)
+	return res
+}
+
+func TestHosts(t *testing.T) {
+	hs := New()
+	hs.Handle("example.com", site("apex"))
+	hs.Handle("*.example.com", site("any subdomain"))
+	hs.Handle("*.blog.example.com", site("blog"))
+	hs.Handle("admin.example.com", site("admin"))
+	hs.Handle("[::1]", site("loopback"))
+	hs.Handle("my_host~1.example", site("underscore"))
+
+	tests := []struct {
+		host string
+		body string
+	}{
+		{"example.com", "apex"},
+		{"EXAMPLE.com:8080", "apex"},
+		{"example.com.", "apex"},
+		{"www.example.com", "any subdomain"},
+		{"a.b.example.com", "any subdomain"},
+		{"admin.example.com", "admin"},
+		{"me.blog.example.com", "blog"},
+		{"[::1]:42069", "loopback"},
+		{"My_Host~1.example", "underscore"},
+	}
+	for _, tt := range tests {
+		res := serve(t, hs, "GET / HTTP/1.1\r\nHost: "+tt.host+"\r\n\r\n")
+		assert.Equal(t, response.StatusOK, res.StatusCode, tt.host)
+		assert.Equal(t, tt.body, string(res.Body), tt.host)
+	}
+
+	// Test: The authority of an absolute-form target overrides Host
+	res := serve(t, hs, "GET http://admin.example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "admin", string(res.Body))
+
+	// Test: Unknown hosts without a default
+	res = serve(t, hs, "GET / HTTP/1.1\r\nHost: example.org\r\n\r\n")
+	assert.Equal(t, response.StatusMisdirectedRequest, res.StatusCode)
+	res = serve(t, hs, "GET / HTTP/1.1\r\nHost: notexample.com\r\n\r\n")
+	assert.Equal(t, response.StatusMisdirectedRequest, res.StatusCode)
+
+	// Test: Default host, also for requests without a Host
+	hs.Default = site("default")
+	res = serve(t, hs, "GET / HTTP/1.1\r\nHost: example.org\r\n\r\n")
+	assert.Equal(t, "default", string(res.Body))
+	res = serve(t, hs, "GET / HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "default", string(res.Body))
+
+	// Test: Invalid and duplicate hosts panic
+	for _, host := range []string{"", "*.", "exa mple.com", "example.com:80", "a.*.example.com", "example.com"} {
+		assert.Panics(t, func() { hs.Handle(host, site("bad")) }, host)
+	}
+}